@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// parseAdminReset reads ALLOW_RESET ("true"/"false", default false) and
+// returns the ADMIN_API_KEY that POST /admin/reset must be called with, or ""
+// when resetting is off. Turning it on without a key is an error, so the
+// endpoint is never open to anyone who can reach the server.
+func parseAdminReset() (string, error) {
+	v := os.Getenv("ALLOW_RESET")
+	if v == "" {
+		return "", nil
+	}
+	allow, err := strconv.ParseBool(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid ALLOW_RESET %q: must be true or false", v)
+	}
+	if !allow {
+		return "", nil
+	}
+	key := os.Getenv("ADMIN_API_KEY")
+	if key == "" {
+		return "", fmt.Errorf("ALLOW_RESET=true requires ADMIN_API_KEY to be set")
+	}
+	return key, nil
+}
+
+// resetDatabase returns the handler for POST /admin/reset, which wipes every
+// student, organization and audit entry for a fresh dev or test run. Student
+// IDs start again at 1. The request must carry apiKey in X-API-Key, as for
+// PUT /read-only. main only registers the route when ALLOW_RESET is on.
+func resetDatabase(apiKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasAPIKey(w, r, apiKey) {
+			return
+		}
+
+		var deleted int64
+		err := withRetry(r.Context(), func() error {
+			tx, err := db.BeginTx(r.Context(), nil)
+			if err != nil {
+				return fmt.Errorf("Database error: Could not start transaction: %w", err)
+			}
+			for _, table := range []string{"audit_log", "students", "organizations"} {
+				result, err := tx.Exec("DELETE FROM " + table)
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				if table == "students" {
+					deleted, _ = result.RowsAffected()
+				}
+			}
+			if err := resetStudentIDSeq(tx); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("Transaction commit failed: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Database reset failed", "error", err)
+			writeTxError(w, err)
+			return
+		}
+		// Replaying a key now would answer 201 for a student that's gone.
+		idempotency.clear()
+		slog.WarnContext(r.Context(), "Database reset", "students_deleted", deleted)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Database reset",
+			"deleted": deleted,
+		})
+	}
+}
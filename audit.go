@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Every insert, update, delete and restore of a student writes an audit_log
+// row in the same transaction as the change, holding the row as it was
+// before and after (old_values/new_values, JSON; null for the side that
+// didn't exist). Snapshots include deleted_at, so a soft delete shows up as
+// that column going from null to a time.
+
+// snapshotStudents reads the current rows for ids inside tx, keyed by id.
+// IDs with no row are simply absent.
+func snapshotStudents(tx *sql.Tx, ids []int64) (map[int64]map[string]interface{}, error) {
+	snap := map[int64]map[string]interface{}{}
+	if len(ids) == 0 {
+		return snap, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := tx.Query("SELECT "+studentColumns+", deleted_at FROM students WHERE id IN ("+placeholders(len(ids))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec, err := scanStudentRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		snap[rec["id"].(int64)] = rec
+	}
+	return snap, rows.Err()
+}
+
+// scanStudentRecord reads one row of studentColumns plus deleted_at: the
+// whole stored record, as used by audit snapshots and JSON backups.
+func scanStudentRecord(rows *sql.Rows) (map[string]interface{}, error) {
+	var id int64
+	var name, org, email, number sql.NullString
+	var age sql.NullInt64
+	var gpa sql.NullFloat64
+	var createdAt, updatedAt, deletedAt sql.NullTime
+	if err := rows.Scan(&id, &name, &age, &gpa, &org, &email, &number, &createdAt, &updatedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":                id,
+		"name":              nullableString(name),
+		"age":               nullableInt(age),
+		"gpa":               nullableFloat(gpa),
+		"organization_name": nullableString(org),
+		"email":             nullableString(email),
+		"student_number":    nullableString(number),
+		"created_at":        nullableTime(createdAt),
+		"updated_at":        nullableTime(updatedAt),
+		"deleted_at":        nullableTime(deletedAt),
+	}, nil
+}
+
+// recordAudit writes one audit_log row per id from the before and after
+// snapshots.
+func recordAudit(tx *sql.Tx, action string, ids []int64, before, after map[int64]map[string]interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare("INSERT INTO audit_log (student_id, action, old_values, new_values) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range ids {
+		oldValues, err := auditJSON(before[id])
+		if err != nil {
+			return err
+		}
+		newValues, err := auditJSON(after[id])
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(id, action, oldValues, newValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditJSON encodes a snapshot, or returns nil (SQL NULL) for a missing one.
+func auditJSON(snap map[string]interface{}) (interface{}, error) {
+	if snap == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// auditMutation snapshots ids, runs mutate, snapshots them again and records
+// the change, all on tx. The caller still owns commit and rollback.
+func auditMutation(tx *sql.Tx, action string, ids []int64, mutate func() error) error {
+	before, err := snapshotStudents(tx, ids)
+	if err != nil {
+		return err
+	}
+	if err := mutate(); err != nil {
+		return err
+	}
+	after, err := snapshotStudents(tx, ids)
+	if err != nil {
+		return err
+	}
+	return recordAudit(tx, action, ids, before, after)
+}
+
+// auditedExec runs a single-student UPDATE in its own transaction, audited
+// as action, and returns the rows affected. Nothing is logged when no row
+// changed.
+func auditedExec(ctx context.Context, action string, id int64, query string, args ...interface{}) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	before, err := snapshotStudents(tx, []int64{id})
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		tx.Rollback()
+		return 0, err
+	}
+	after, err := snapshotStudents(tx, []int64{id})
+	if err == nil {
+		err = recordAudit(tx, action, []int64{id}, before, after)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// getStudentHistory returns a student's audit_log entries, oldest first. It's
+// 404 only when the student has never existed; a student created before
+// auditing began has an empty history.
+func getStudentHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := db.Query(`
+    SELECT id, action, old_values, new_values, changed_at
+    FROM audit_log
+    WHERE student_id = ?
+    ORDER BY changed_at, id
+    `, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "History query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	type historyEntry struct {
+		ID        int64           `json:"id"`
+		Action    string          `json:"action"`
+		Old       json.RawMessage `json:"old"`
+		New       json.RawMessage `json:"new"`
+		ChangedAt interface{}     `json:"changed_at"`
+	}
+	history := []historyEntry{}
+	for rows.Next() {
+		var e historyEntry
+		var oldValues, newValues sql.NullString
+		var changedAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.Action, &oldValues, &newValues, &changedAt); err != nil {
+			slog.ErrorContext(r.Context(), "Scan failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		e.Old = rawJSONOrNull(oldValues)
+		e.New = rawJSONOrNull(newValues)
+		e.ChangedAt = nullableTime(changedAt)
+		history = append(history, e)
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(history) == 0 {
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(*) FROM students WHERE id = ?", id).Scan(&exists); err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if exists == 0 {
+			jsonError(w, http.StatusNotFound, "Student not found")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+func rawJSONOrNull(v sql.NullString) json.RawMessage {
+	if !v.Valid {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(v.String)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exportStudentsJSON streams every student, soft-deleted ones included, as a
+// JSON array of full records (timestamps and deleted_at too). It's the backup
+// format importStudentsJSON restores.
+func exportStudentsJSON(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT " + studentColumns + ", deleted_at FROM students ORDER BY id")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "JSON export query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="students.json"`)
+
+	w.Write([]byte("["))
+	enc := json.NewEncoder(w)
+	for n := 0; rows.Next(); n++ {
+		rec, err := scanStudentRecord(rows)
+		if err != nil {
+			// Headers are already sent, so all we can do is log and stop.
+			slog.ErrorContext(r.Context(), "JSON export scan failed", "error", err)
+			break
+		}
+		if n > 0 {
+			w.Write([]byte(","))
+		}
+		enc.Encode(rec)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(r.Context(), "JSON export iteration failed", "error", err)
+	}
+	w.Write([]byte("]\n"))
+}
+
+// backupStudent is one record of a JSON backup. Name, age and GPA can be
+// null, as they can in the table, and are restored as NULL.
+type backupStudent struct {
+	ID               int64      `json:"id"`
+	Name             *string    `json:"name"`
+	Age              *int       `json:"age"`
+	GPA              *float64   `json:"gpa"`
+	OrganizationName string     `json:"organization_name"`
+	Email            string     `json:"email"`
+	StudentNumber    string     `json:"student_number"`
+	CreatedAt        *time.Time `json:"created_at"`
+	UpdatedAt        *time.Time `json:"updated_at"`
+	DeletedAt        *time.Time `json:"deleted_at"`
+}
+
+// importStudentsJSON restores a backup from exportStudentsJSON in one
+// transaction. IDs are kept: a record whose ID exists overwrites that
+// student, any other is inserted with its ID. created_at and deleted_at are
+// restored (a missing created_at defaults to now), but updated_at is always
+// now, so restored students show up in /students/changes. Every record is
+// validated and checked for email/student number clashes before anything is
+// written: with the other records, and with students not in the backup,
+// whose values are the only ones that will still be there afterwards. So a
+// backup that swaps values between students still restores. Students not in
+// the backup are left alone.
+func importStudentsJSON(w http.ResponseWriter, r *http.Request) {
+	var students []backupStudent
+	if !requireJSON(w, r) {
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&students); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if len(students) == 0 {
+		jsonError(w, http.StatusBadRequest, "No students provided")
+		return
+	}
+
+	seen := map[int64]bool{}
+	ids := make([]int64, len(students))
+	for i := range students {
+		s := &students[i]
+		if s.Name != nil {
+			*s.Name = strings.TrimSpace(*s.Name)
+		}
+		s.OrganizationName = normalizeOrganization(s.OrganizationName)
+		s.Email = strings.TrimSpace(s.Email)
+		s.StudentNumber = strings.TrimSpace(s.StudentNumber)
+		if s.GPA != nil {
+			*s.GPA = roundGPA(*s.GPA)
+		}
+
+		var err error
+		switch {
+		case s.ID < 1:
+			err = &fieldError{"id", "ID must be a positive integer"}
+		case seen[s.ID]:
+			err = &fieldError{"id", fmt.Sprintf("Duplicate ID %d", s.ID)}
+		case s.Name != nil:
+			err = validateName(*s.Name)
+		}
+		if err == nil && s.Age != nil {
+			err = validateAge(*s.Age)
+		}
+		if err == nil && s.GPA != nil {
+			err = validateGPA(*s.GPA)
+		}
+		if err == nil {
+			err = validateEmail(s.Email)
+		}
+		if err == nil {
+			err = validateStudentNumber(s.StudentNumber)
+		}
+		if err != nil {
+			fe := err.(*fieldError)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Row %d: %s", i, fe.Message),
+				"index": i,
+				"field": fe.Field,
+			})
+			return
+		}
+		seen[s.ID] = true
+		ids[i] = s.ID
+	}
+
+	var inserted, updated []int64
+	err := withRetry(r.Context(), func() error {
+		inserted, updated = nil, nil
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			return fmt.Errorf("Database error: Could not start transaction: %w", err)
+		}
+
+		before, err := snapshotStudents(tx, ids)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		others, err := otherStudentsValues(tx, seen)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := backupClash(students, others); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		orgs := newOrgNames(tx)
+		now := time.Now().UTC()
+		for _, s := range students {
+			if s.OrganizationName, err = orgs.canonical(s.OrganizationName); err != nil {
+				tx.Rollback()
+				return err
+			}
+			createdAt := now
+			if s.CreatedAt != nil {
+				createdAt = s.CreatedAt.UTC()
+			}
+			var deletedAt interface{}
+			if s.DeletedAt != nil {
+				deletedAt = s.DeletedAt.UTC()
+			}
+
+			if before[s.ID] != nil {
+				_, err = tx.Exec(`
+    UPDATE students
+    SET name = ?, age = ?, gpa = ?, organization_name = ?, email = ?, student_number = ?,
+        created_at = ?, updated_at = ?, deleted_at = ?
+    WHERE id = ?
+    `, s.Name, s.Age, s.GPA, s.OrganizationName, nullIfEmpty(s.Email), nullIfEmpty(s.StudentNumber),
+					createdAt, now, deletedAt, s.ID)
+				updated = append(updated, s.ID)
+			} else {
+				_, err = tx.Exec(`
+    INSERT INTO students (id, name, age, gpa, organization_name, email, student_number, created_at, updated_at, deleted_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, s.ID, s.Name, s.Age, s.GPA, s.OrganizationName, nullIfEmpty(s.Email), nullIfEmpty(s.StudentNumber),
+					createdAt, now, deletedAt)
+				inserted = append(inserted, s.ID)
+			}
+			if err != nil {
+				tx.Rollback()
+				slog.ErrorContext(r.Context(), "JSON import write failed", "id", s.ID, "error", err)
+				return fmt.Errorf("Import failed due to database error: %w", err)
+			}
+		}
+
+		// Restored IDs can be above the sequence; move it past them.
+		err = syncStudentIDSeq(tx)
+		if err == nil {
+			err = syncOrganizations(tx, orgs.names())
+		}
+		var after map[int64]map[string]interface{}
+		if err == nil {
+			after, err = snapshotStudents(tx, ids)
+		}
+		if err == nil {
+			err = recordAudit(tx, "insert", inserted, before, after)
+		}
+		if err == nil {
+			err = recordAudit(tx, "update", updated, before, after)
+		}
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(r.Context(), "Audit log write failed", "error", err)
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(r.Context(), "JSON import commit failed", "error", err)
+			return fmt.Errorf("Transaction commit failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTxError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"inserted": len(inserted),
+		"updated":  len(updated),
+	})
+}
+
+// takenValues holds the emails (lowercased) and student numbers in use.
+type takenValues struct {
+	emails, numbers map[string]bool
+}
+
+// otherStudentsValues returns the emails and student numbers of every student
+// whose ID isn't in skip, deleted ones included since their values are still
+// taken (see emailTaken).
+func otherStudentsValues(tx *sql.Tx, skip map[int64]bool) (takenValues, error) {
+	taken := takenValues{emails: map[string]bool{}, numbers: map[string]bool{}}
+	rows, err := tx.Query("SELECT id, lower(email), student_number FROM students WHERE email IS NOT NULL OR student_number IS NOT NULL")
+	if err != nil {
+		return taken, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var email, number sql.NullString
+		if err := rows.Scan(&id, &email, &number); err != nil {
+			return taken, err
+		}
+		if skip[id] {
+			continue
+		}
+		if email.Valid {
+			taken.emails[email.String] = true
+		}
+		if number.Valid {
+			taken.numbers[number.String] = true
+		}
+	}
+	return taken, rows.Err()
+}
+
+// backupClash returns a 409 *statusError for the first record whose email or
+// student number is also used by an earlier record, or is in others.
+func backupClash(students []backupStudent, others takenValues) error {
+	emails, numbers := map[string]bool{}, map[string]bool{}
+	for i, s := range students {
+		if key := strings.ToLower(s.Email); key != "" {
+			if emails[key] || others.emails[key] {
+				return &statusError{http.StatusConflict, fmt.Sprintf("Row %d: a student with email %s already exists", i, s.Email)}
+			}
+			emails[key] = true
+		}
+		if s.StudentNumber != "" {
+			if numbers[s.StudentNumber] || others.numbers[s.StudentNumber] {
+				return &statusError{http.StatusConflict, fmt.Sprintf("Row %d: a student with student number %s already exists", i, s.StudentNumber)}
+			}
+			numbers[s.StudentNumber] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestImportJSONRestoresNulls(t *testing.T) {
+	newTestDB(t)
+	rec := serve(t, importStudentsJSON, http.MethodPost, "/students/import.json",
+		`[{"id":7,"name":null,"age":null,"gpa":null,"updated_at":"2020-01-01T00:00:00Z"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	var name sql.NullString
+	var age sql.NullInt64
+	var gpa sql.NullFloat64
+	var updatedAt time.Time
+	err := db.QueryRow("SELECT name, age, gpa, updated_at FROM students WHERE id = 7").Scan(&name, &age, &gpa, &updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name.Valid || age.Valid || gpa.Valid {
+		t.Errorf("got name %v, age %v, gpa %v, want all NULL", name, age, gpa)
+	}
+	if time.Since(updatedAt) > time.Minute {
+		t.Errorf("updated_at = %v, want the time of the import", updatedAt)
+	}
+}
+
+func TestImportJSONChecksUniquenessFirst(t *testing.T) {
+	newTestDB(t)
+	mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"email":"ann@example.com"}`)
+	mustInsert(t, `{"name":"Bob","age":20,"gpa":3.0,"email":"bob@example.com"}`)
+	mustInsert(t, `{"name":"Cy","age":20,"gpa":3.0,"email":"cy@example.com"}`)
+
+	// Swapping values between restored students is fine.
+	rec := serve(t, importStudentsJSON, http.MethodPost, "/students/import.json",
+		`[{"id":1,"name":"Ann","age":20,"gpa":3.0,"email":"bob@example.com"},
+		  {"id":2,"name":"Bob","age":20,"gpa":3.0,"email":"ann@example.com"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("swap: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// Cy isn't in this backup, so their email is still taken.
+	rec = serve(t, importStudentsJSON, http.MethodPost, "/students/import.json",
+		`[{"id":4,"name":"Di","age":20,"gpa":3.0},
+		  {"id":5,"name":"Ed","age":20,"gpa":3.0,"email":"CY@example.com"}]`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("clash: status %d, body %s", rec.Code, rec.Body)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM students WHERE id IN (4, 5)").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d students written by a refused import", n)
+	}
+}
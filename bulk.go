@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// placeholders returns n comma-separated "?" markers for an IN (...) list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// bulkDeleteStudents soft-deletes every student in a JSON array of IDs in one
+// transaction, the same way deleteStudent does. IDs that don't exist (or are
+// already deleted) are reported back rather than failing the request.
+func bulkDeleteStudents(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	if !requireJSON(w, r) {
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body: expected an array of student IDs")
+		return
+	}
+	if len(ids) == 0 {
+		jsonError(w, http.StatusBadRequest, "No student IDs provided")
+		return
+	}
+
+	// Drop repeats so "deleted" counts each student once.
+	seen := map[int64]bool{}
+	args := []interface{}{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			args = append(args, id)
+		}
+	}
+
+	var found map[int64]bool
+	var deleted int64
+	err := withRetry(r.Context(), func() error {
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			return fmt.Errorf("Database error: Could not start transaction: %w", err)
+		}
+
+		rows, err := tx.Query("SELECT id FROM students WHERE deleted_at IS NULL AND id IN ("+placeholders(len(args))+")", args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		found = map[int64]bool{}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return err
+			}
+			found[id] = true
+		}
+		rows.Close()
+
+		ids := make([]int64, 0, len(found))
+		for _, id := range args {
+			if found[id.(int64)] {
+				ids = append(ids, id.(int64))
+			}
+		}
+		var result sql.Result
+		err = auditMutation(tx, "delete", ids, func() error {
+			var err error
+			result, err = tx.Exec("UPDATE students SET deleted_at = now(), updated_at = now() WHERE deleted_at IS NULL AND id IN ("+placeholders(len(args))+")", args...)
+			return err
+		})
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(r.Context(), "Bulk delete failed", "error", err)
+			return fmt.Errorf("Bulk delete failed: %w", err)
+		}
+		deleted, _ = result.RowsAffected()
+
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(r.Context(), "Transaction commit failed", "error", err)
+			return fmt.Errorf("Transaction commit failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTxError(w, err)
+		return
+	}
+
+	notFound := []int64{}
+	for _, id := range args {
+		if !found[id.(int64)] {
+			notFound = append(notFound, id.(int64))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted":   deleted,
+		"not_found": notFound,
+	})
+}
+
+// bulkUpdateOrganization moves every student from one organization to
+// another, e.g. after a rename or merge. Soft-deleted students move too so a
+// later restore doesn't bring back the old name.
+func bulkUpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	body.From = strings.TrimSpace(body.From)
+	body.To = strings.TrimSpace(body.To)
+	if body.From == "" || body.To == "" {
+		jsonError(w, http.StatusBadRequest, "Both from and to organization names are required")
+		return
+	}
+
+	updated, err := moveOrganization(r.Context(), body.From, body.To)
+	if err != nil {
+		writeTxError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated": updated,
+	})
+}
+
+// renameOrganization renames an organization everywhere it's used:
+// PUT /organizations/{oldName} with {"name": "newName"}. oldName is matched
+// case-insensitively, and it's 404 when no student has it.
+func renameOrganization(w http.ResponseWriter, r *http.Request) {
+	oldName := mux.Vars(r)["oldName"]
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		jsonError(w, http.StatusBadRequest, "New organization name is required")
+		return
+	}
+
+	updated, err := moveOrganization(r.Context(), oldName, body.Name)
+	if err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if updated == 0 {
+		jsonError(w, http.StatusNotFound, "No student has organization "+oldName)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated": updated,
+	})
+}
+
+// moveOrganization moves every student in organization "from", compared
+// case-insensitively, to "to", soft-deleted ones included so a later restore
+// doesn't bring back the old name, and returns how many students moved.
+// Nothing is written when no student is in "from". If "to" already exists in
+// another case, students take its spelling and the two organizations merge;
+// the emptied "from" is removed. A change of case only ("chess club" to
+// "Chess Club") respells the organization itself. Transient errors are
+// retried.
+func moveOrganization(ctx context.Context, from, to string) (int64, error) {
+	var updated int64
+	err := withRetry(ctx, func() error {
+		updated = 0
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("Database error: Could not start transaction: %w", err)
+		}
+		defer tx.Rollback() // no-op once committed
+
+		var ids []int64
+		rows, err := tx.Query("SELECT id FROM students WHERE lower(organization_name) = lower(?)", from)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		target := to
+		if strings.EqualFold(from, to) {
+			// DuckDB 1.1 can't UPDATE organizations.name (its UNIQUE index
+			// trips the bug described in initDB), so the row is replaced
+			// instead: syncOrganizations below recreates it in the new
+			// spelling and relinks its students, in this transaction.
+			if _, err := tx.Exec("DELETE FROM organizations WHERE lower(name) = lower(?)", from); err != nil {
+				return err
+			}
+		} else if target, err = newOrgNames(tx).canonical(to); err != nil {
+			return err
+		}
+
+		var result sql.Result
+		err = auditMutation(tx, "update", ids, func() error {
+			var err error
+			result, err = tx.Exec("UPDATE students SET organization_name = ?, updated_at = now() WHERE lower(organization_name) = lower(?)", target, from)
+			if err != nil {
+				return err
+			}
+			if err := syncOrganizations(tx, []string{target}); err != nil {
+				return err
+			}
+			if strings.EqualFold(from, target) {
+				return nil
+			}
+			_, err = tx.Exec("DELETE FROM organizations WHERE lower(name) = lower(?)", from)
+			return err
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Bulk org update failed", "error", err)
+			return fmt.Errorf("Update failed: %w", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(ctx, "Transaction commit failed", "error", err)
+			return fmt.Errorf("Transaction commit failed: %w", err)
+		}
+		updated, _ = result.RowsAffected()
+		return nil
+	})
+	return updated, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// studentOrgs returns each student's organization_name by ID.
+func studentOrgs(t *testing.T) map[int64]string {
+	t.Helper()
+	rows, err := db.Query("SELECT id, organization_name FROM students")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	orgs := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var org string
+		if err := rows.Scan(&id, &org); err != nil {
+			t.Fatal(err)
+		}
+		orgs[id] = org
+	}
+	return orgs
+}
+
+// orgRows returns the organizations table's names.
+func orgRows(t *testing.T) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT name FROM organizations ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func rename(t *testing.T, from, to string) int {
+	t.Helper()
+	rec := serveRoute(t, "/organizations/{oldName:.+}", renameOrganization, http.MethodPut,
+		"/organizations/"+url.PathEscape(from), `{"name":"`+to+`"}`)
+	return rec.Code
+}
+
+func TestRenameOrganizationCaseOnly(t *testing.T) {
+	newTestDB(t)
+	ann := mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"organization_name":"chess club"}`)
+	bob := mustInsert(t, `{"name":"Bob","age":20,"gpa":3.0,"organization_name":"Debate"}`)
+
+	// The old name is matched in any case.
+	if code := rename(t, "CHESS CLUB", "Chess Club"); code != http.StatusOK {
+		t.Fatalf("rename: status %d", code)
+	}
+	if got, want := studentOrgs(t), map[int64]string{ann: "Chess Club", bob: "Debate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("students' organizations = %v, want %v", got, want)
+	}
+	if got, want := orgRows(t), []string{"Chess Club", "Debate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("organizations = %v, want %v", got, want)
+	}
+}
+
+func TestRenameMissingOrganizationChangesNothing(t *testing.T) {
+	newTestDB(t)
+	mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"organization_name":"Chess Club"}`)
+
+	if code := rename(t, "Chess Society", "chess club"); code != http.StatusNotFound {
+		t.Fatalf("rename: status %d, want 404", code)
+	}
+	if got, want := orgRows(t), []string{"Chess Club"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("organizations = %v, want %v", got, want)
+	}
+}
+
+func TestRenameIntoExistingOrganizationMerges(t *testing.T) {
+	newTestDB(t)
+	ann := mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"organization_name":"Chess Club"}`)
+	bob := mustInsert(t, `{"name":"Bob","age":20,"gpa":3.0,"organization_name":"Chess Society"}`)
+
+	if code := rename(t, "Chess Society", "chess club"); code != http.StatusOK {
+		t.Fatalf("rename: status %d", code)
+	}
+	if got, want := studentOrgs(t), map[int64]string{ann: "Chess Club", bob: "Chess Club"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("students' organizations = %v, want %v", got, want)
+	}
+	if got, want := orgRows(t), []string{"Chess Club"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("organizations = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// studentChange is a row of /students/changes: the student plus deleted_at,
+// so a soft delete reaches the consumer as a tombstone.
+type studentChange struct {
+	Student
+	DeletedAt *string `json:"deleted_at"`
+}
+
+// getStudentChanges returns every student with updated_at after ?since=
+// (RFC 3339), oldest change first, for incremental syncs. Soft-deleted
+// students are included with deleted_at set; deletes and restores bump
+// updated_at so they show up here. next_since is the newest updated_at
+// returned, at full precision, to pass as since on the next call; with no
+// changes it's since again.
+func getStudentChanges(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		jsonError(w, http.StatusBadRequest, "since is required")
+		return
+	}
+	since, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp, e.g. 2024-01-02T15:04:05Z")
+		return
+	}
+	since = since.UTC()
+
+	rows, err := db.Query(
+		"SELECT "+studentColumns+", deleted_at, updated_at FROM students WHERE updated_at > ? ORDER BY updated_at, id",
+		since,
+	)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Changes query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	changes := []studentChange{}
+	next := since
+	for rows.Next() {
+		var deletedAt sql.NullTime
+		var updatedAt time.Time
+		s, err := scanStudent(rows, &deletedAt, &updatedAt)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Scan failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c := studentChange{Student: s}
+		if deletedAt.Valid {
+			ts := formatTimestamp(deletedAt.Time)
+			c.DeletedAt = &ts
+		}
+		changes = append(changes, c)
+		next = updatedAt.UTC()
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"since":      since.Format(time.RFC3339Nano),
+		"next_since": next.Format(time.RFC3339Nano),
+		"count":      len(changes),
+		"students":   changes,
+	})
+}
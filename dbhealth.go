@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// defaultDBHealthInterval is used when DB_HEALTH_INTERVAL isn't set.
+const defaultDBHealthInterval = 30 * time.Second
+
+// dbReopenAttempts is how many times watchDB reopens the database after a
+// failed health check before it gives up until the next one. The wait
+// between attempts starts at dbReopenBaseDelay and doubles.
+const (
+	dbReopenAttempts  = 3
+	dbReopenBaseDelay = time.Second
+)
+
+// dbHealthTimeout bounds one health check. A check that times out is taken
+// to mean the database is busy (a long request holds the only connection),
+// not broken.
+const dbHealthTimeout = 5 * time.Second
+
+// dbConnector is the connector behind db; initDB sets it.
+var dbConnector *reopeningConnector
+
+// parseDBHealthInterval reads DB_HEALTH_INTERVAL as a Go duration ("30s",
+// "1m"), falling back to defaultDBHealthInterval. 0 turns the check off.
+func parseDBHealthInterval() (time.Duration, error) {
+	v := os.Getenv("DB_HEALTH_INTERVAL")
+	if v == "" {
+		return defaultDBHealthInterval, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid DB_HEALTH_INTERVAL %q: must be a non-negative duration such as 30s", v)
+	}
+	return d, nil
+}
+
+// reopeningConnector opens DuckDB connections like the driver's own
+// connector, except that reopen can throw the database handle away so the
+// next connection opens the file afresh. Connections from an old handle are
+// dropped by the pool instead of being reused (see genConn).
+type reopeningConnector struct {
+	dsn string
+
+	mu  sync.Mutex
+	cur *duckdb.Connector // nil until the next Connect after reopen
+	gen uint64            // bumped by every reopen
+}
+
+// newReopeningConnector opens dsn right away, so a bad path still fails at
+// startup.
+func newReopeningConnector(dsn string) (*reopeningConnector, error) {
+	cur, err := duckdb.NewConnector(dsn, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &reopeningConnector{dsn: dsn, cur: cur}, nil
+}
+
+func (c *reopeningConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cur == nil {
+		cur, err := duckdb.NewConnector(c.dsn, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.cur = cur
+	}
+	conn, err := c.cur.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &genConn{Conn: conn.(*duckdb.Conn), connector: c, gen: c.gen}, nil
+}
+
+func (c *reopeningConnector) Driver() driver.Driver { return duckdb.Driver{} }
+
+// Close closes the current handle; sql.DB.Close calls it.
+func (c *reopeningConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
+
+// reopen closes the current handle; the next Connect opens the file again.
+func (c *reopeningConnector) reopen() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if c.cur != nil {
+		c.cur.Close()
+		c.cur = nil
+	}
+}
+
+func (c *reopeningConnector) current(gen uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return gen == c.gen
+}
+
+// genConn is a DuckDB connection that remembers which handle it came from.
+// database/sql asks IsValid before pooling a connection and ResetSession
+// before reusing one, so connections from before a reopen are closed instead.
+type genConn struct {
+	*duckdb.Conn
+	connector *reopeningConnector
+	gen       uint64
+}
+
+func (c *genConn) IsValid() bool { return c.connector.current(c.gen) }
+
+func (c *genConn) ResetSession(context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// checkDB runs a query that has to read the students table. A timeout isn't
+// reported as a failure; see dbHealthTimeout.
+func checkDB() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbHealthTimeout)
+	defer cancel()
+	var n int64
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM students").Scan(&n)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+// watchDB checks the database every interval and, when a check fails (say
+// the volume holding the file blipped), reopens it up to dbReopenAttempts
+// times. Without this every query would keep failing until a restart. It
+// runs for the life of the process. main doesn't start it for an in-memory
+// database, since reopening one would lose everything in it.
+func watchDB(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := checkDB()
+		if err == nil {
+			continue
+		}
+		slog.Error("Database health check failed, reopening", "error", err)
+
+		delay := dbReopenBaseDelay
+		for attempt := 1; ; attempt++ {
+			dbConnector.reopen()
+			// Close idle connections from the old handle now rather than
+			// when they're next used.
+			idle := db.Stats().MaxOpenConnections
+			db.SetMaxIdleConns(0)
+			db.SetMaxIdleConns(idle)
+
+			if err = checkDB(); err == nil {
+				slog.Info("Database reopened", "attempt", attempt)
+				break
+			}
+			if attempt == dbReopenAttempts {
+				slog.Error("Giving up reopening the database until the next health check", "attempts", attempt, "error", err)
+				break
+			}
+			slog.Warn("Reopening the database failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
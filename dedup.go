@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// duplicateKeys are the columns ?by= may group on.
+var duplicateKeys = map[string]bool{
+	"name":              true,
+	"organization_name": true,
+	"email":             true,
+}
+
+// findDuplicates lists groups of students that share the ?by= columns
+// (comma-separated, default "name"), for the data-cleanup tool. Values are
+// compared trimmed and case-insensitively, so "Ann Lee" and "ann lee " group
+// together; each group reports one spelling of each key plus the member IDs
+// in ascending order. Soft-deleted students are ignored, as are students with
+// any of the keys missing or blank, which aren't duplicates of each other.
+func findDuplicates(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "name"
+	}
+	var keys, where, groupBy, selects []string
+	for _, k := range strings.Split(by, ",") {
+		k = strings.TrimSpace(k)
+		if !duplicateKeys[k] {
+			jsonError(w, http.StatusBadRequest, fmt.Sprintf("invalid by %q: must be name, organization_name and/or email", k))
+			return
+		}
+		keys = append(keys, k)
+		where = append(where, k+" IS NOT NULL AND trim("+k+") <> ''")
+		groupBy = append(groupBy, "lower(trim("+k+"))")
+		selects = append(selects, "MIN("+k+")")
+	}
+
+	rows, err := db.Query(
+		"SELECT " + strings.Join(selects, ", ") + ", COUNT(*), list(id ORDER BY id) FROM students" +
+			" WHERE deleted_at IS NULL AND " + strings.Join(where, " AND ") +
+			" GROUP BY " + strings.Join(groupBy, ", ") +
+			" HAVING COUNT(*) > 1 ORDER BY COUNT(*) DESC, MIN(id)",
+	)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Duplicate query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	groups := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]string, len(keys))
+		var count int
+		var ids interface{}
+		dest := make([]interface{}, 0, len(keys)+2)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &count, &ids)
+		if err := rows.Scan(dest...); err != nil {
+			slog.ErrorContext(r.Context(), "Scan failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		group := map[string]interface{}{"count": count, "ids": ids}
+		for i, k := range keys {
+			group[k] = values[i]
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// invalidRules are the checks findInvalidStudents runs, in response order:
+// the same rules the write paths validate, plus a missing organization.
+var invalidRules = []struct {
+	violation string
+	cond      string
+}{
+	{"age_out_of_range", "age < 0 OR age > 120"},
+	{"gpa_out_of_range", "gpa < 0.0 OR gpa > 4.0"},
+	{"empty_name", "name IS NULL OR trim(name) = ''"},
+	{"missing_organization", "organization_name IS NULL OR trim(organization_name) = ''"},
+}
+
+// findInvalidStudents reports rows that break the validation rules, for
+// auditing data written before the rules existed (or outside the API). Each
+// rule gets a group, empty or not, with the offending IDs in ascending order;
+// a student breaking several rules appears in each. Soft-deleted students are
+// skipped unless ?includeDeleted=true.
+func findInvalidStudents(w http.ResponseWriter, r *http.Request) {
+	selects := make([]string, len(invalidRules))
+	for i, rule := range invalidRules {
+		selects[i] = "list(id ORDER BY id) FILTER (WHERE " + rule.cond + ")"
+	}
+
+	ids := make([]interface{}, len(invalidRules))
+	dest := make([]interface{}, len(ids))
+	for i := range ids {
+		dest[i] = &ids[i]
+	}
+	err := db.QueryRow("SELECT " + strings.Join(selects, ", ") + " FROM students WHERE 1=1" + deletedClause(r)).Scan(dest...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Invalid data query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	groups := make([]map[string]interface{}, len(invalidRules))
+	for i, rule := range invalidRules {
+		// list() over no rows is NULL rather than an empty list.
+		group, _ := ids[i].([]interface{})
+		if group == nil {
+			group = []interface{}{}
+		}
+		groups[i] = map[string]interface{}{
+			"violation": rule.violation,
+			"count":     len(group),
+			"ids":       group,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// mergeStudents resolves a duplicate group: POST /students/merge with
+// {"keep": id, "remove": [ids...]} soft-deletes every "remove" student and
+// returns the one kept. Everything happens in one transaction, and nothing
+// changes unless all IDs exist (and aren't already deleted). A transient lock
+// or conflict error retries the whole transaction.
+func mergeStudents(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Keep   *int64  `json:"keep"`
+		Remove []int64 `json:"remove"`
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if body.Keep == nil {
+		jsonError(w, http.StatusBadRequest, "keep is required")
+		return
+	}
+	if len(body.Remove) == 0 {
+		jsonError(w, http.StatusBadRequest, "remove must list at least one student ID")
+		return
+	}
+
+	keep := *body.Keep
+	seen := map[int64]bool{}
+	remove := []interface{}{}
+	for _, id := range body.Remove {
+		if id == keep {
+			jsonError(w, http.StatusBadRequest, "keep must not also be in remove")
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			remove = append(remove, id)
+		}
+	}
+	all := append([]interface{}{keep}, remove...)
+
+	err := withRetry(r.Context(), func() error {
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			return fmt.Errorf("Database error: Could not start transaction: %w", err)
+		}
+
+		rows, err := tx.Query("SELECT id FROM students WHERE deleted_at IS NULL AND id IN ("+placeholders(len(all))+")", all...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		found := map[int64]bool{}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return err
+			}
+			found[id] = true
+		}
+		rows.Close()
+
+		missing := []string{}
+		for _, id := range all {
+			if !found[id.(int64)] {
+				missing = append(missing, fmt.Sprint(id))
+			}
+		}
+		if len(missing) > 0 {
+			tx.Rollback()
+			return &statusError{http.StatusNotFound, "Students not found: " + strings.Join(missing, ", ")}
+		}
+
+		removeIDs := make([]int64, len(remove))
+		for i, id := range remove {
+			removeIDs[i] = id.(int64)
+		}
+		err = auditMutation(tx, "delete", removeIDs, func() error {
+			_, err := tx.Exec("UPDATE students SET deleted_at = now(), updated_at = now() WHERE id IN ("+placeholders(len(remove))+")", remove...)
+			return err
+		})
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(r.Context(), "Merge failed", "error", err)
+			return fmt.Errorf("Merge failed: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(r.Context(), "Transaction commit failed", "error", err)
+			return fmt.Errorf("Transaction commit failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTxError(w, err)
+		return
+	}
+
+	students, err := queryStudents("SELECT "+studentColumns+" FROM students WHERE id = ?", keep)
+	if err != nil || len(students) == 0 {
+		jsonError(w, http.StatusInternalServerError, "Merged, but could not load the kept student")
+		return
+	}
+	writeStudent(w, r, students[0])
+}
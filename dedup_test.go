@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDuplicatesSkipMissingKeys(t *testing.T) {
+	newTestDB(t)
+	// Only Ann and ann share an email; the other pairs share a name but
+	// have no email, or an empty or blank one.
+	_, err := db.Exec(`
+    INSERT INTO students (id, name, age, gpa, organization_name, email) VALUES
+      (1, 'Ann', 20, 3.5, 'Chess Club', 'ann@example.com'),
+      (2, 'ann', 20, 3.0, 'Chess Club', 'ANN@example.com'),
+      (3, 'Bob', 20, 3.0, NULL, NULL),
+      (4, 'Bob', 20, 3.0, NULL, NULL),
+      (5, 'Cy', 20, 3.0, '', ''),
+      (6, 'Cy', 20, 3.0, ' ', ' ')
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		by   string
+		want [][]int64
+	}{
+		{"email", [][]int64{{1, 2}}},
+		{"name,email", [][]int64{{1, 2}}},
+		{"organization_name", [][]int64{{1, 2}}},
+		{"name", [][]int64{{1, 2}, {3, 4}, {5, 6}}},
+	} {
+		rec := serve(t, findDuplicates, http.MethodGet, "/students/duplicates?by="+tc.by, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("by=%s: status %d, body %s", tc.by, rec.Code, rec.Body)
+		}
+		var groups []struct {
+			IDs []int64 `json:"ids"`
+		}
+		decodeBody(t, rec, &groups)
+		var got [][]int64
+		for _, g := range groups {
+			got = append(got, g.IDs)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("by=%s: got groups %v, want %v", tc.by, got, tc.want)
+		}
+	}
+}
+
+func TestMergeStudents(t *testing.T) {
+	newTestDB(t)
+	ann := mustInsert(t, `{"name":"Ann","age":20,"gpa":3.5}`)
+	dup := mustInsert(t, `{"name":"ann","age":20,"gpa":3.5}`)
+
+	body := fmt.Sprintf(`{"keep":%d,"remove":[%d,99]}`, ann, dup)
+	rec := serve(t, mergeStudents, http.MethodPost, "/students/merge", body)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("with a missing ID: status %d, want 404; body %s", rec.Code, rec.Body)
+	}
+
+	body = fmt.Sprintf(`{"keep":%d,"remove":[%d]}`, ann, dup)
+	rec = serve(t, mergeStudents, http.MethodPost, "/students/merge", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var deleted bool
+	if err := db.QueryRow("SELECT deleted_at IS NOT NULL FROM students WHERE id = ?", dup).Scan(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Errorf("student %d wasn't soft-deleted", dup)
+	}
+}
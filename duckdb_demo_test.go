@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// studentPage is the part of writeStudentPage's envelope the tests look at.
+type studentPage struct {
+	Total    int       `json:"total"`
+	Students []Student `json:"students"`
+	Next     *string   `json:"next_cursor"`
+}
+
+func pageIDs(p studentPage) []int64 {
+	ids := []int64{}
+	for _, s := range p.Students {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestEmptyCursorStartsFromFirstPage(t *testing.T) {
+	newTestDB(t)
+	for _, name := range []string{"Ann", "Bob", "Cy"} {
+		mustInsert(t, `{"name":"`+name+`","age":20,"gpa":3.0}`)
+	}
+
+	for _, tc := range []struct {
+		order      string
+		first, all []int64
+	}{
+		{"asc", []int64{1, 2}, []int64{1, 2, 3}},
+		{"desc", []int64{3, 2}, []int64{3, 2, 1}},
+	} {
+		t.Run(tc.order, func(t *testing.T) {
+			rec := serve(t, getStudents, http.MethodGet, "/students?limit=2&order="+tc.order+"&cursor=", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+			}
+			var page studentPage
+			decodeBody(t, rec, &page)
+			if got := pageIDs(page); !reflect.DeepEqual(got, tc.first) {
+				t.Fatalf("first page ids = %v, want %v", got, tc.first)
+			}
+			if page.Next == nil {
+				t.Fatal("next_cursor is null on a full page")
+			}
+
+			rec = serve(t, getStudents, http.MethodGet, "/students?limit=2&order="+tc.order+"&cursor="+*page.Next, "")
+			var next studentPage
+			decodeBody(t, rec, &next)
+			if got := append(pageIDs(page), pageIDs(next)...); !reflect.DeepEqual(got, tc.all) {
+				t.Fatalf("ids across pages = %v, want %v", got, tc.all)
+			}
+		})
+	}
+}
+
+func TestConcurrentInsertsGetUniqueIDs(t *testing.T) {
+	newTestDB(t)
+	const n = 50
+	codes := make([]int, n)
+	ids := make([]int64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := serve(t, insertStudent, http.MethodPost, "/students", `{"name":"Student `+strconv.Itoa(i)+`","age":20,"gpa":3.0}`)
+			codes[i] = rec.Code
+			var resp struct {
+				ID int64 `json:"id"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			ids[i] = resp.ID
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[int64]bool{}
+	for i := range codes {
+		if codes[i] != http.StatusCreated {
+			t.Errorf("insert %d: status %d", i, codes[i])
+			continue
+		}
+		if seen[ids[i]] {
+			t.Errorf("insert %d: id %d handed out twice", i, ids[i])
+		}
+		seen[ids[i]] = true
+	}
+	if len(seen) != n {
+		t.Fatalf("%d unique ids, want %d", len(seen), n)
+	}
+}
+
+func TestIDsFollowUpsertedStudents(t *testing.T) {
+	newTestDB(t)
+	rec := serveRoute(t, "/students/{id}", updateStudent, http.MethodPut, "/students/10?upsert=true", `{"name":"Ann","age":20,"gpa":3.0}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upsert: status %d, body %s", rec.Code, rec.Body)
+	}
+	if id := mustInsert(t, `{"name":"Bob","age":20,"gpa":3.0}`); id != 11 {
+		t.Fatalf("id after upserting 10 = %d, want 11", id)
+	}
+}
+
+func TestSearchIgnoresCase(t *testing.T) {
+	newTestDB(t)
+	id := mustInsert(t, `{"name":"Alice Smith","age":20,"gpa":3.5}`)
+	mustInsert(t, `{"name":"Bob Jones","age":21,"gpa":3.1}`)
+
+	for _, q := range []string{"alice", "ALICE"} {
+		rec := serve(t, searchStudentsByName, http.MethodGet, "/students/search?q="+url.QueryEscape(q), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("q=%q: status %d, body %s", q, rec.Code, rec.Body)
+		}
+		var students []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		}
+		decodeBody(t, rec, &students)
+		if len(students) != 1 || students[0].ID != id || students[0].Name != "Alice Smith" {
+			t.Errorf("q=%q: got %+v, want only Alice Smith (id %d)", q, students, id)
+		}
+	}
+}
+
+// TestUpdateOnceIndexedColumns guards against the "Duplicate key ... violates
+// primary key constraint" failure DuckDB 1.1 gave any UPDATE of a column
+// covered by the old secondary indexes. The database starts out with those
+// indexes, as files from older versions do, so initDB has to drop them. The
+// names also check that the UPDATE is parameterized rather than quoted by
+// hand: quotes and backslashes have to come back exactly as sent.
+func TestUpdateOnceIndexedColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE students (id BIGINT PRIMARY KEY, name TEXT, age INTEGER, gpa FLOAT, organization_name TEXT)",
+		"CREATE INDEX idx_students_org ON students (organization_name)",
+		"CREATE INDEX idx_students_age_gpa ON students (age, gpa)",
+		"CREATE INDEX idx_students_name ON students (name)",
+		"INSERT INTO students VALUES (1, 'Ann', 20, 3.0, 'Chess Club')",
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	old.Close()
+
+	t.Setenv("DB_PATH", path)
+	db = initDB()
+	organizations.invalidate()
+	t.Cleanup(func() { db.Close() })
+
+	for _, want := range []string{"Annabel", `O'Brien \ Ann`, `Ann\'; DROP TABLE students; --`} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"name": want, "age": 21, "gpa": 3.5, "organization_name": "Debate Society",
+		})
+		rec := serveRoute(t, "/students/{id}", updateStudent, http.MethodPut, "/students/1", string(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("update to %q: status %d, body %s", want, rec.Code, rec.Body)
+		}
+
+		var name, org string
+		var age int
+		var gpa float64
+		err = db.QueryRow("SELECT name, age, gpa, organization_name FROM students WHERE id = 1").Scan(&name, &age, &gpa, &org)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != want || age != 21 || gpa != 3.5 || org != "Debate Society" {
+			t.Fatalf("after update to %q got %q, %d, %v, %q", want, name, age, gpa, org)
+		}
+	}
+}
+
+func TestPatchUsesExistingOrganizationSpelling(t *testing.T) {
+	newTestDB(t)
+	mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"organization_name":"Chess Club"}`)
+	id := mustInsert(t, `{"name":"Bob","age":20,"gpa":3.0}`)
+
+	rec := serveRoute(t, "/students/{id}", patchStudent, http.MethodPatch, "/students/"+strconv.FormatInt(id, 10), `{"organization_name":"chess club"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch: status %d, body %s", rec.Code, rec.Body)
+	}
+	var org string
+	if err := db.QueryRow("SELECT organization_name FROM students WHERE id = ?", id).Scan(&org); err != nil {
+		t.Fatal(err)
+	}
+	if org != "Chess Club" {
+		t.Fatalf("organization_name = %q, want %q", org, "Chess Club")
+	}
+}
+
+func TestDeletedStudentKeepsEmail(t *testing.T) {
+	newTestDB(t)
+	id := mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"email":"ann@example.com"}`)
+	rec := serveRoute(t, "/students/{id}", deleteStudent, http.MethodDelete, "/students/"+strconv.FormatInt(id, 10), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, insertStudent, http.MethodPost, "/students", `{"name":"Bob","age":20,"gpa":3.0,"email":"ANN@example.com"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("reusing a deleted student's email: status %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// contentETag is a strong ETag over an exact response body.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// listETag is a weak ETag for a student list, derived from the request URL,
+// its Accept header (which picks plain JSON or JSON:API) and a cheap summary of the matching rows rather than the body itself, so
+// it can be checked before any rows are streamed. Any insert or update moves
+// MAX(updated_at); a delete moves the table-wide MAX(deleted_at); a restore or
+// delete changes the count.
+func listETag(r *http.Request, total int, lastUpdated, lastDeleted sql.NullTime) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s?%s|%s|%d|%d|%d", r.URL.Path, r.URL.RawQuery, strings.Join(r.Header.Values("Accept"), ","), total, unixNanoOrZero(lastUpdated), unixNanoOrZero(lastDeleted))
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+func unixNanoOrZero(t sql.NullTime) int64 {
+	if !t.Valid {
+		return 0
+	}
+	return t.Time.UnixNano()
+}
+
+// notModified sets the ETag header and, if the request's If-None-Match
+// already names it, answers 304 and reports true. Comparison is weak, as
+// RFC 9110 requires for If-None-Match.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// csvHeader is the column order shared by the CSV export and import.
+var csvHeader = []string{"id", "name", "age", "gpa", "organization_name", "email", "student_number", "created_at", "updated_at"}
+
+// exportStudentsCSV streams students as a CSV download. It accepts the same
+// optional filter params as filterStudents.
+func exportStudentsCSV(w http.ResponseWriter, r *http.Request) {
+	where, args, err := studentFilterClause(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := db.Query(
+		"SELECT "+studentColumns+" FROM students WHERE 1=1"+where+" ORDER BY id",
+		args...,
+	)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Export query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="students.csv"`)
+
+	// Headers are already sent, so all we can do on failure is log.
+	if _, err := writeStudentsCSV(w, rows); err != nil {
+		slog.ErrorContext(r.Context(), "Export failed", "error", err)
+	}
+}
+
+// writeStudentsCSV writes csvHeader and then every studentColumns row from
+// rows, returning how many rows were written. NULLs become empty cells.
+func writeStudentsCSV(w io.Writer, rows *sql.Rows) (int, error) {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	n := 0
+	for rows.Next() {
+		var id int64
+		var name, org, email, number sql.NullString
+		var age sql.NullInt64
+		var gpa sql.NullFloat64
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&id, &name, &age, &gpa, &org, &email, &number, &createdAt, &updatedAt); err != nil {
+			cw.Flush()
+			return n, fmt.Errorf("scan: %w", err)
+		}
+		ageCell, gpaCell, createdCell, updatedCell := "", "", "", ""
+		if age.Valid {
+			ageCell = strconv.FormatInt(age.Int64, 10)
+		}
+		if gpa.Valid {
+			gpaCell = strconv.FormatFloat(gpa.Float64, 'f', -1, 64)
+		}
+		if createdAt.Valid {
+			createdCell = formatTimestamp(createdAt.Time)
+		}
+		if updatedAt.Valid {
+			updatedCell = formatTimestamp(updatedAt.Time)
+		}
+		cw.Write([]string{
+			strconv.FormatInt(id, 10),
+			name.String,
+			ageCell,
+			gpaCell,
+			org.String,
+			email.String,
+			number.String,
+			createdCell,
+			updatedCell,
+		})
+		n++
+	}
+	cw.Flush()
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("iterate: %w", err)
+	}
+	return n, cw.Error()
+}
+
+// exportStudentsXLSX sends students as an Excel workbook with the CSV
+// export's columns and filter params. Unlike CSV the cell types survive
+// opening the file in Excel: ages and GPAs are numbers, timestamps are dates
+// (UTC), and IDs and student numbers are text so leading zeros are kept.
+// The workbook is built in memory before anything is sent, so a failure is
+// still a proper 500.
+func exportStudentsXLSX(w http.ResponseWriter, r *http.Request) {
+	where, args, err := studentFilterClause(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := db.Query(
+		"SELECT "+studentColumns+" FROM students WHERE 1=1"+where+" ORDER BY id",
+		args...,
+	)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Export query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	f := excelize.NewFile()
+	defer f.Close()
+	if _, err := writeStudentsXLSX(f, rows); err != nil {
+		slog.ErrorContext(r.Context(), "XLSX export failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="students.xlsx"`)
+	if _, err := f.WriteTo(w); err != nil {
+		slog.ErrorContext(r.Context(), "XLSX export failed", "error", err)
+	}
+}
+
+// writeStudentsXLSX fills the "Students" sheet of f with a csvHeader row
+// and then every studentColumns row from rows, returning how many rows were
+// written. NULLs become empty cells.
+func writeStudentsXLSX(f *excelize.File, rows *sql.Rows) (int, error) {
+	const sheet = "Students"
+	if err := f.SetSheetName(f.GetSheetName(0), sheet); err != nil {
+		return 0, err
+	}
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return 0, err
+	}
+	text, err := f.NewStyle(&excelize.Style{NumFmt: 49}) // "@"
+	if err != nil {
+		return 0, err
+	}
+	dateFmt := "yyyy-mm-dd hh:mm:ss"
+	date, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFmt})
+	if err != nil {
+		return 0, err
+	}
+
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return 0, err
+	}
+	if err := sw.SetColWidth(1, len(csvHeader), 20); err != nil {
+		return 0, err
+	}
+	if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return 0, err
+	}
+	header := make([]interface{}, len(csvHeader))
+	for i, h := range csvHeader {
+		header[i] = excelize.Cell{StyleID: bold, Value: h}
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return 0, err
+	}
+
+	// cell wraps a non-NULL value in style; NULL stays an empty cell.
+	cell := func(valid bool, style int, v interface{}) interface{} {
+		if !valid {
+			return nil
+		}
+		return excelize.Cell{StyleID: style, Value: v}
+	}
+
+	n := 0
+	for rows.Next() {
+		var id int64
+		var name, org, email, number sql.NullString
+		var age sql.NullInt64
+		var gpa sql.NullFloat64
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&id, &name, &age, &gpa, &org, &email, &number, &createdAt, &updatedAt); err != nil {
+			return n, fmt.Errorf("scan: %w", err)
+		}
+		axis, err := excelize.CoordinatesToCellName(1, n+2)
+		if err != nil {
+			return n, err
+		}
+		err = sw.SetRow(axis, []interface{}{
+			cell(true, text, strconv.FormatInt(id, 10)),
+			cell(name.Valid, 0, name.String),
+			cell(age.Valid, 0, age.Int64),
+			cell(gpa.Valid, 0, gpa.Float64),
+			cell(org.Valid, 0, org.String),
+			cell(email.Valid, 0, email.String),
+			cell(number.Valid, text, number.String),
+			cell(createdAt.Valid, date, createdAt.Time.UTC()),
+			cell(updatedAt.Valid, date, updatedAt.Time.UTC()),
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("iterate: %w", err)
+	}
+	return n, sw.Flush()
+}
+
+// importError reports a CSV row that was skipped during import.
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importStudentsCSV reads an uploaded CSV (multipart field "file") with the
+// export's columns and inserts every valid row in one transaction, retried on
+// transient errors. Invalid rows, and rows clashing with an existing student,
+// are skipped and reported back in line order; the id column is ignored and
+// new IDs are assigned, as are the timestamps. The email and student_number
+// columns are optional.
+func importStudentsCSV(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Missing CSV upload in form field \"file\"")
+		return
+	}
+	defer file.Close()
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1 // row length is checked per row so one bad line doesn't abort the import
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Could not read CSV header: "+err.Error())
+		return
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"name", "age", "gpa", "organization_name"} {
+		if _, ok := cols[required]; !ok {
+			jsonError(w, http.StatusBadRequest, "CSV header is missing column: "+required)
+			return
+		}
+	}
+
+	type row struct {
+		line                     int
+		name, org, email, number string
+		age                      int
+		gpa                      float64
+	}
+	var valid []row
+	skipped := []importError{}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// FieldPos panics after a failed Read, so the line comes from
+			// the error instead.
+			if pe, ok := err.(*csv.ParseError); ok {
+				skipped = append(skipped, importError{Line: pe.StartLine, Error: err.Error()})
+				continue
+			}
+			jsonError(w, http.StatusBadRequest, "Could not read CSV: "+err.Error())
+			return
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) != len(header) {
+			skipped = append(skipped, importError{Line: line, Error: fmt.Sprintf("expected %d fields, got %d", len(header), len(record))})
+			continue
+		}
+
+		rw := row{line: line}
+		rw.name = strings.TrimSpace(record[cols["name"]])
+		rw.org = normalizeOrganization(record[cols["organization_name"]])
+		rw.age, err = strconv.Atoi(strings.TrimSpace(record[cols["age"]]))
+		if err != nil {
+			skipped = append(skipped, importError{Line: line, Error: "Invalid age"})
+			continue
+		}
+		rw.gpa, err = strconv.ParseFloat(strings.TrimSpace(record[cols["gpa"]]), 64)
+		if err != nil {
+			skipped = append(skipped, importError{Line: line, Error: "Invalid GPA"})
+			continue
+		}
+		rw.gpa = roundGPA(rw.gpa)
+		if err := validateStudent(rw.name, rw.age, rw.gpa); err != nil {
+			skipped = append(skipped, importError{Line: line, Error: err.Error()})
+			continue
+		}
+		if i, ok := cols["email"]; ok {
+			rw.email = strings.TrimSpace(record[i])
+			if err := validateEmail(rw.email); err != nil {
+				skipped = append(skipped, importError{Line: line, Error: err.Error()})
+				continue
+			}
+		}
+		if i, ok := cols["student_number"]; ok {
+			rw.number = strings.TrimSpace(record[i])
+			if err := validateStudentNumber(rw.number); err != nil {
+				skipped = append(skipped, importError{Line: line, Error: err.Error()})
+				continue
+			}
+		}
+		valid = append(valid, rw)
+	}
+
+	// Rows that clash with an existing student are only found inside the
+	// transaction, so they're collected apart from the rows skipped above
+	// and start over on a retry.
+	var ids []int64
+	var clashes []importError
+	err = withRetry(r.Context(), func() error {
+		ids, clashes = nil, nil
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			return fmt.Errorf("Database error: Could not start transaction: %w", err)
+		}
+		defer tx.Rollback() // no-op once committed
+
+		stmt, err := tx.Prepare(`
+       INSERT INTO students (id, name, age, gpa, organization_name, email, student_number, created_at, updated_at)
+       VALUES (?, ?, ?, ?, ?, ?, ?, now(), now())
+    `)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		orgs := newOrgNames(tx)
+		for _, rw := range valid {
+			if taken, err := emailTaken(tx, rw.email, 0); err != nil {
+				return err
+			} else if taken {
+				clashes = append(clashes, importError{Line: rw.line, Error: "A student with that email already exists"})
+				continue
+			}
+			if taken, err := studentNumberTaken(tx, rw.number, 0); err != nil {
+				return err
+			} else if taken {
+				clashes = append(clashes, importError{Line: rw.line, Error: "A student with that student number already exists"})
+				continue
+			}
+			if rw.org, err = orgs.canonical(rw.org); err != nil {
+				return err
+			}
+			id, err := nextStudentID(tx)
+			if err != nil {
+				return fmt.Errorf("Database error: Failed to get next ID: %w", err)
+			}
+			if _, err := stmt.Exec(id, rw.name, rw.age, rw.gpa, rw.org, nullIfEmpty(rw.email), nullIfEmpty(rw.number)); err != nil {
+				slog.ErrorContext(r.Context(), "CSV import insert failed", "line", rw.line, "error", err)
+				return fmt.Errorf("Import failed due to database error: %w", err)
+			}
+			ids = append(ids, id)
+		}
+
+		err = syncOrganizations(tx, orgs.names())
+		var after map[int64]map[string]interface{}
+		if err == nil {
+			after, err = snapshotStudents(tx, ids)
+		}
+		if err == nil {
+			err = recordAudit(tx, "insert", ids, nil, after)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Audit log write failed", "error", err)
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(r.Context(), "CSV import commit failed", "error", err)
+			return fmt.Errorf("Transaction commit failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTxError(w, err)
+		return
+	}
+
+	skipped = append(skipped, clashes...)
+	sort.SliceStable(skipped, func(i, j int) bool {
+		return skipped[i].Line < skipped[j].Line
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"inserted": len(ids),
+		"skipped":  len(skipped),
+		"errors":   skipped,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postCSV uploads csv to importStudentsCSV as a multipart form.
+func postCSV(t *testing.T, csv string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "students.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(csv))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/students/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	importStudentsCSV(rec, req)
+	return rec
+}
+
+type importResponse struct {
+	Inserted int           `json:"inserted"`
+	Errors   []importError `json:"errors"`
+}
+
+func TestImportCSVReportsErrorsInLineOrder(t *testing.T) {
+	newTestDB(t)
+	mustInsert(t, `{"name":"Ann","age":20,"gpa":3.0,"email":"ann@example.com"}`)
+
+	// Line 2 clashes with Ann, which is only found in the transaction; line
+	// 3 fails validation up front.
+	rec := postCSV(t, "name,age,gpa,organization_name,email\n"+
+		"Bob,20,3.0,Chess Club,ann@example.com\n"+
+		"Cy,abc,3.0,Chess Club,\n"+
+		"Di,20,3.0,Chess Club,\n")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp importResponse
+	decodeBody(t, rec, &resp)
+	if resp.Inserted != 1 || len(resp.Errors) != 2 || resp.Errors[0].Line != 2 || resp.Errors[1].Line != 3 {
+		t.Fatalf("got %+v, want 1 inserted and errors on lines 2 then 3", resp)
+	}
+}
+
+func TestImportCSVSkipsMalformedQuotes(t *testing.T) {
+	newTestDB(t)
+
+	rec := postCSV(t, "name,age,gpa,organization_name\n"+
+		"\"bad\"x,1,3.0,Chess Club\n"+
+		"Di,20,3.0,Chess Club\n")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	var resp importResponse
+	decodeBody(t, rec, &resp)
+	if resp.Inserted != 1 || len(resp.Errors) != 1 || resp.Errors[0].Line != 2 {
+		t.Fatalf("got %+v, want 1 inserted and an error on line 2", resp)
+	}
+}
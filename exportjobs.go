@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// exportJobTTL is how long a finished export job, and its file, is kept
+// before it's pruned.
+const exportJobTTL = time.Hour
+
+// exportJob is one background CSV export. Fields are guarded by exportJobs.mu.
+type exportJob struct {
+	ID         string
+	Status     string // running, done or failed
+	Rows       int
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+	path       string
+}
+
+// exportJobs tracks every export job by ID. Jobs live only in memory, so a
+// restart forgets them (their temp files are left to the OS).
+var exportJobs = struct {
+	mu   sync.Mutex
+	jobs map[string]*exportJob
+}{jobs: map[string]*exportJob{}}
+
+// createExportJob starts a CSV export in the background and answers 202 with
+// the job right away; poll getExportJob until it's done, then download it.
+// It takes the same filter params as the synchronous /students/export.
+func createExportJob(w http.ResponseWriter, r *http.Request) {
+	where, args, err := studentFilterClause(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	job := &exportJob{
+		ID:        hex.EncodeToString(idBytes),
+		Status:    "running",
+		CreatedAt: time.Now().UTC(),
+	}
+
+	exportJobs.mu.Lock()
+	pruneExportJobs()
+	exportJobs.jobs[job.ID] = job
+	started := *job
+	exportJobs.mu.Unlock()
+
+	go runExportJob(job, "SELECT "+studentColumns+" FROM students WHERE 1=1"+where+" ORDER BY id", args)
+
+	w.Header().Set("Location", "/students/export-jobs/"+job.ID)
+	writeExportJob(w, http.StatusAccepted, &started)
+}
+
+// runExportJob writes the CSV to a temp file and records the outcome on job.
+// It doesn't use the request's context: the request is long gone.
+func runExportJob(job *exportJob, query string, args []interface{}) {
+	n, path, err := func() (int, string, error) {
+		f, err := os.CreateTemp("", "students-export-*.csv")
+		if err != nil {
+			return 0, "", err
+		}
+		defer f.Close()
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			os.Remove(f.Name())
+			return 0, "", err
+		}
+		defer rows.Close()
+
+		n, err := writeStudentsCSV(f, rows)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return 0, "", err
+		}
+		return n, f.Name(), nil
+	}()
+
+	exportJobs.mu.Lock()
+	defer exportJobs.mu.Unlock()
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	if err != nil {
+		slog.Error("Export job failed", "job", job.ID, "error", err)
+		job.Status = "failed"
+		job.Error = err.Error()
+		return
+	}
+	job.Status = "done"
+	job.Rows = n
+	job.path = path
+	slog.Info("Export job finished", "job", job.ID, "rows", n)
+}
+
+// pruneExportJobs drops jobs that finished more than exportJobTTL ago and
+// deletes their files. The caller holds exportJobs.mu.
+func pruneExportJobs() {
+	cutoff := time.Now().Add(-exportJobTTL)
+	for id, job := range exportJobs.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			if job.path != "" {
+				os.Remove(job.path)
+			}
+			delete(exportJobs.jobs, id)
+		}
+	}
+}
+
+// lookupExportJob returns a copy of the job named in the URL, or nil.
+func lookupExportJob(r *http.Request) *exportJob {
+	exportJobs.mu.Lock()
+	defer exportJobs.mu.Unlock()
+	pruneExportJobs()
+	job, ok := exportJobs.jobs[mux.Vars(r)["id"]]
+	if !ok {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// getExportJob reports a job's status; once it's done the response carries
+// the download URL.
+func getExportJob(w http.ResponseWriter, r *http.Request) {
+	job := lookupExportJob(r)
+	if job == nil {
+		jsonError(w, http.StatusNotFound, "Export job not found")
+		return
+	}
+	writeExportJob(w, http.StatusOK, job)
+}
+
+// downloadExportJob sends a finished job's CSV. It's 409 while the job is
+// still running or if it failed.
+func downloadExportJob(w http.ResponseWriter, r *http.Request) {
+	job := lookupExportJob(r)
+	if job == nil {
+		jsonError(w, http.StatusNotFound, "Export job not found")
+		return
+	}
+	if job.Status != "done" {
+		jsonError(w, http.StatusConflict, "Export job is "+job.Status)
+		return
+	}
+
+	f, err := os.Open(job.path)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Opening export file failed", "job", job.ID, "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="students.csv"`)
+	http.ServeContent(w, r, "students.csv", *job.FinishedAt, f)
+}
+
+func writeExportJob(w http.ResponseWriter, status int, job *exportJob) {
+	resp := map[string]interface{}{
+		"id":          job.ID,
+		"status":      job.Status,
+		"rows":        job.Rows,
+		"created_at":  formatTimestamp(job.CreatedAt),
+		"finished_at": nil,
+	}
+	if job.FinishedAt != nil {
+		resp["finished_at"] = formatTimestamp(*job.FinishedAt)
+	}
+	if job.Error != "" {
+		resp["error"] = job.Error
+	}
+	if job.Status == "done" {
+		resp["download_url"] = "/students/export-jobs/" + job.ID + "/download"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
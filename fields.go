@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// studentFields are the columns a client can pick with ?fields=, in
+// studentColumns order.
+var studentFields = strings.Split(studentColumns, ", ")
+
+// parseFields reads the optional comma-separated ?fields= list (e.g.
+// fields=id,name) and returns the SELECT list to use plus the fields in the
+// order given. Without the param every column is selected. Unknown names are
+// a 400; repeats are ignored.
+func parseFields(r *http.Request) (string, []string, error) {
+	if !r.URL.Query().Has("fields") {
+		return studentColumns, studentFields, nil
+	}
+
+	var fields []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || seen[f] {
+			continue
+		}
+		if !isStudentField(f) {
+			return "", nil, fmt.Errorf("invalid field %q: must be one of %s", f, studentColumns)
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("fields must name at least one of %s", studentColumns)
+	}
+	return strings.Join(fields, ", "), fields, nil
+}
+
+func isStudentField(f string) bool {
+	for _, known := range studentFields {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+// scanStudentFields reads one row selected with the given fields. Like
+// scanStudent, NULLs come out as nil.
+func scanStudentFields(rows *sql.Rows, fields []string) (map[string]interface{}, error) {
+	dest := make([]interface{}, len(fields))
+	for i, f := range fields {
+		switch f {
+		case "id":
+			dest[i] = new(int64)
+		case "age":
+			dest[i] = new(sql.NullInt64)
+		case "gpa":
+			dest[i] = new(sql.NullFloat64)
+		case "created_at", "updated_at":
+			dest[i] = new(sql.NullTime)
+		default:
+			dest[i] = new(sql.NullString)
+		}
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	s := make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		switch v := dest[i].(type) {
+		case *int64:
+			s[f] = *v
+		case *sql.NullInt64:
+			s[f] = nullableInt(*v)
+		case *sql.NullFloat64:
+			s[f] = nullableFloat(*v)
+		case *sql.NullTime:
+			s[f] = nullableTime(*v)
+		case *sql.NullString:
+			s[f] = nullableString(*v)
+		}
+	}
+	return s, nil
+}
+
+// marshalStudentFields encodes a row from scanStudentFields as a JSON object
+// with its keys in fields order (encoding/json would sort a map's keys).
+// Fields missing from s are skipped.
+func marshalStudentFields(s map[string]interface{}, fields []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, f := range fields {
+		v, ok := s[f]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%q:", f)
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
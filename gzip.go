@@ -0,0 +1,161 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest body worth compressing; below it the gzip
+// header and trailer eat most of the savings.
+const gzipMinSize = 1024
+
+// gzipMiddleware compresses responses for clients that accept gzip. The
+// first gzipMinSize bytes are buffered to decide: smaller bodies, bodies the
+// handler already encoded (Content-Encoding set, e.g. /metrics) and
+// already-compressed content types go out as-is.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(gw, r)
+		// Not deferred: if the handler panics, whatever it buffered is
+		// dropped so recoveryMiddleware can still send a clean 500.
+		gw.close()
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, i.e.
+// lists gzip (or *) without q=0.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressedTypePrefixes are Content-Types that don't shrink under gzip.
+var compressedTypePrefixes = []string{"image/", "video/", "audio/", "application/gzip", "application/zip", "application/x-gzip"}
+
+// gzipResponseWriter buffers output until it knows whether to compress,
+// then either streams through a gzip.Writer or writes through unchanged.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.decided || g.status != 0 {
+		return
+	}
+	g.status = status
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		g.buf = append(g.buf, b...)
+		if len(g.buf) >= gzipMinSize {
+			if err := g.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Flush commits to a decision with whatever is buffered so streaming
+// handlers still reach the client promptly.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.start(len(g.buf) >= gzipMinSize)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start sends the headers, compressing if compress is set and nothing about
+// the response rules it out, then writes out the buffer.
+func (g *gzipResponseWriter) start(compress bool) error {
+	g.decided = true
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		// Sniff now: net/http would otherwise sniff the compressed bytes.
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" && bodyAllowed(g.status) && !isCompressedType(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close finishes the response once the handler returns.
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		if g.status == 0 && len(g.buf) == 0 {
+			return // handler wrote nothing; let net/http send its default 200
+		}
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func isCompressedType(contentType string) bool {
+	for _, prefix := range compressedTypePrefixes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
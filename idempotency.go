@@ -0,0 +1,97 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Idempotency-Key responses are kept in memory for idempotencyTTL, and at
+// most idempotencyMaxKeys of them; past that the least recently used key is
+// dropped. Keys don't survive a restart.
+const (
+	idempotencyTTL     = 24 * time.Hour
+	idempotencyMaxKeys = 10000
+	idempotencyMaxLen  = 255
+)
+
+// idempotentResponse is the stored outcome for one Idempotency-Key. done is
+// closed once the first request finishes; body is nil if it didn't succeed.
+type idempotentResponse struct {
+	key     string
+	body    []byte
+	expires time.Time
+	done    chan struct{}
+	elem    *list.Element
+}
+
+// idempotencyCache is a small LRU of Idempotency-Key responses.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	lru     *list.List // front is most recently used
+}
+
+var idempotency = &idempotencyCache{
+	entries: map[string]*idempotentResponse{},
+	lru:     list.New(),
+}
+
+// begin looks up key. If an earlier request with the key is finished or still
+// running its entry is returned with seen=true; the caller should wait on
+// done and replay body. Otherwise a pending entry is reserved for the caller,
+// who must hand it to finish.
+func (c *idempotencyCache) begin(key string) (e *idempotentResponse, seen bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		if e.body == nil || time.Now().Before(e.expires) {
+			c.lru.MoveToFront(e.elem)
+			return e, true
+		}
+		c.remove(e)
+	}
+
+	for c.lru.Len() >= idempotencyMaxKeys {
+		c.remove(c.lru.Back().Value.(*idempotentResponse))
+	}
+	e = &idempotentResponse{key: key, done: make(chan struct{})}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	return e, false
+}
+
+// finish records the response for a reserved entry. A nil body means the
+// request failed, so the key is released and a retry inserts for real.
+func (c *idempotencyCache) finish(e *idempotentResponse, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if body == nil {
+		if c.entries[e.key] == e {
+			c.remove(e)
+		}
+	} else {
+		e.body = body
+		e.expires = time.Now().Add(idempotencyTTL)
+	}
+	close(e.done)
+}
+
+// clear forgets every finished key. Requests still running keep their
+// entries so finish can release them.
+func (c *idempotencyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.body != nil {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *idempotencyCache) remove(e *idempotentResponse) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+}
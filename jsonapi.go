@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// jsonAPIMediaType is the JSON:API media type. A client that lists it in
+// Accept gets student responses as JSON:API documents instead of plain JSON.
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// wantsJSONAPI reports whether r accepts the JSON:API media type, and adds
+// Vary: Accept since the response depends on it. The spec forbids media type
+// parameters other than ext and profile, so an entry carrying any other one
+// doesn't count.
+func wantsJSONAPI(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Accept")
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || mediaType != jsonAPIMediaType {
+				continue
+			}
+			delete(params, "ext")
+			delete(params, "profile")
+			if len(params) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonAPIResource is a JSON:API resource object for one student.
+type jsonAPIResource struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// studentAttributes is a Student without its id, which a resource object
+// carries outside attributes. The outer ID hides the embedded one and is
+// always omitted.
+type studentAttributes struct {
+	Student
+	ID *struct{} `json:"id,omitempty"`
+}
+
+// studentResource wraps s as a "students" resource object.
+func studentResource(s Student) (jsonAPIResource, error) {
+	attrs, err := json.Marshal(studentAttributes{Student: s})
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+	return jsonAPIResource{Type: "students", ID: strconv.FormatInt(s.ID, 10), Attributes: attrs}, nil
+}
+
+// marshalStudent encodes s as a plain JSON object, or as a JSON:API document
+// when jsonAPI is set.
+func marshalStudent(s Student, jsonAPI bool) ([]byte, error) {
+	if !jsonAPI {
+		return json.Marshal(s)
+	}
+	res, err := studentResource(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{"data": res})
+}
+
+// studentContentType is the Content-Type for a student response.
+func studentContentType(jsonAPI bool) string {
+	if jsonAPI {
+		return jsonAPIMediaType
+	}
+	return "application/json"
+}
+
+// writeStudent answers with one student, as plain JSON or JSON:API.
+func writeStudent(w http.ResponseWriter, r *http.Request, s Student) {
+	jsonAPI := wantsJSONAPI(w, r)
+	body, err := marshalStudent(s, jsonAPI)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", studentContentType(jsonAPI))
+	w.Write(append(body, '\n'))
+}
+
+// writeStudentList answers with an unpaginated list of students: a plain
+// JSON array, or for JSON:API a document whose meta holds the count.
+func writeStudentList(w http.ResponseWriter, r *http.Request, students []Student) {
+	jsonAPI := wantsJSONAPI(w, r)
+	if !jsonAPI {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(students)
+		return
+	}
+
+	data := make([]jsonAPIResource, 0, len(students))
+	for _, s := range students {
+		res, err := studentResource(s)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		data = append(data, res)
+	}
+	w.Header().Set("Content-Type", jsonAPIMediaType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": data,
+		"meta": map[string]int{"count": len(students)},
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// initLogger makes the default slog logger write JSON to stderr so the log
+// aggregator can pick fields apart. LOG_LEVEL picks the minimum level (debug,
+// info, warn or error; default info). The standard log package is routed
+// through the same handler. Records logged with a request's context carry its
+// request_id.
+func initLogger() error {
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", v)
+		}
+	}
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+	return nil
+}
+
+// requestIDHandler adds the request_id from requestIDMiddleware to every
+// record logged with that request's context.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// fatal logs msg at error level with the given key/value pairs and exits.
+// Like log.Fatal, it does not run deferred calls.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,36 +1,181 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// listenAddr returns the address to serve on. ADDR (host:port) wins over
+// PORT (just the port); with neither set we listen on :8080.
+func listenAddr() (string, error) {
+	if addr := os.Getenv("ADDR"); addr != "" {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", fmt.Errorf("invalid ADDR %q: %v", addr, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return "", fmt.Errorf("invalid ADDR %q: bad port", addr)
+		}
+		return addr, nil
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid PORT %q: must be 1-65535", port)
+		}
+		return ":" + port, nil
+	}
+	return ":8080", nil
+}
+
 func main() {
+	if err := initLogger(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	addr, err := listenAddr()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	bodyLimit, err := maxBodyBytes()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if bulkChunkSize, err = parseBulkChunkSize(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if dbMaxRetries, err = parseDBMaxRetries(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if organizations.ttl, err = parseOrgCacheTTL(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	ro, err := parseReadOnly()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	readOnly.Store(ro)
+	corsCfg, err := parseCORSConfig()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	cors := corsMiddleware(corsCfg)
+	resetKey, err := parseAdminReset()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	healthInterval, err := parseDBHealthInterval()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	rateLimit, err := parseRateLimit()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	adminKey := os.Getenv("ADMIN_API_KEY")
+
 	db = initDB()
 	defer db.Close() // Add this to properly close DB on shutdown
 
+	go refreshStudentCount(studentCountInterval)
+	if healthInterval > 0 && os.Getenv("DB_PATH") != ":memory:" {
+		go watchDB(healthInterval)
+	}
+
 	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.Use(loggingMiddleware)
+	router.Use(metricsMiddleware)
+	router.Use(recoveryMiddleware)
+	router.Use(cors)
+	router.Use(rateLimitMiddleware(rateLimit, adminKey))
+	router.Use(readOnlyMiddleware)
+	router.Use(bodyLimitMiddleware(bodyLimit))
+	router.Use(gzipMiddleware)
+	router.Use(orgCacheMiddleware)
+
+	// mux only runs middleware on matched routes, so give preflight requests
+	// a route of their own; corsMiddleware answers them before this handler.
+	router.PathPrefix("/").Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// mux skips middleware for unmatched requests, so wrap these by hand.
+	router.NotFoundHandler = requestIDMiddleware(loggingMiddleware(metricsMiddleware(cors(http.HandlerFunc(notFound)))))
+	router.MethodNotAllowedHandler = requestIDMiddleware(loggingMiddleware(metricsMiddleware(cors(methodNotAllowedHandler(router)))))
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Backend API running"))
 	})
+	router.HandleFunc("/healthz", healthz).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/openapi.json", getOpenAPISpec).Methods("GET")
+	router.HandleFunc("/read-only", getReadOnly).Methods("GET")
+	if adminKey != "" {
+		router.HandleFunc("/read-only", setReadOnly(adminKey)).Methods("PUT")
+	}
+	if resetKey != "" {
+		router.HandleFunc("/admin/reset", resetDatabase(resetKey)).Methods("POST")
+	}
 
 	// IMPORTANT: Specific routes MUST come BEFORE parameterized routes
 	router.HandleFunc("/students/search", searchStudentsByName).Methods("GET")
+	router.HandleFunc("/students/autocomplete", autocompleteStudents).Methods("GET")
 	router.HandleFunc("/students/filter", filterStudents).Methods("GET")
+	router.HandleFunc("/students/count", countStudents).Methods("GET")
+	router.HandleFunc("/students/ages", getAges).Methods("GET")
+	router.HandleFunc("/students/recent", getRecentStudents).Methods("GET")
+	router.HandleFunc("/students/changes", getStudentChanges).Methods("GET")
+	router.HandleFunc("/students/top", getTopStudents).Methods("GET")
+	router.HandleFunc("/students/random", getRandomStudents).Methods("GET")
+	router.HandleFunc("/students/at-risk", getAtRiskStudents).Methods("GET")
+	router.HandleFunc("/students/duplicates", findDuplicates).Methods("GET")
+	router.HandleFunc("/students/invalid", findInvalidStudents).Methods("GET")
+	router.HandleFunc("/students/merge", mergeStudents).Methods("POST")
+	router.HandleFunc("/students/by-organization/{org:.+}", getStudentsByOrganization).Methods("GET")
+	router.HandleFunc("/students/by-number/{number}", getStudentByNumber).Methods("GET")
+	router.HandleFunc("/students/export", exportStudentsCSV).Methods("GET")
+	router.HandleFunc("/students/export.xlsx", exportStudentsXLSX).Methods("GET")
+	router.HandleFunc("/students/import", importStudentsCSV).Methods("POST")
+	router.HandleFunc("/students/export-jobs", createExportJob).Methods("POST")
+	router.HandleFunc("/students/export-jobs/{id}", getExportJob).Methods("GET")
+	router.HandleFunc("/students/export-jobs/{id}/download", downloadExportJob).Methods("GET")
+	router.HandleFunc("/students/export.json", exportStudentsJSON).Methods("GET")
+	router.HandleFunc("/students/import.json", importStudentsJSON).Methods("POST")
+	router.HandleFunc("/students/stats", getStudentStats).Methods("GET")
+	router.HandleFunc("/students/stats/by-organization", getStatsByOrganization).Methods("GET")
+	router.HandleFunc("/students/stats/age-histogram", getAgeHistogram).Methods("GET")
+	router.HandleFunc("/students/stats/gpa-histogram", getGPAHistogram).Methods("GET")
+	router.HandleFunc("/students/stats/gpa-percentiles", getGPAPercentiles).Methods("GET")
 	router.HandleFunc("/students/bulk", bulkInsertStudents).Methods("POST")
+	router.HandleFunc("/students/stream", streamInsertStudents).Methods("POST")
+	router.HandleFunc("/students/bulk-delete", bulkDeleteStudents).Methods("POST")
+	router.HandleFunc("/students/bulk-update-org", bulkUpdateOrganization).Methods("POST")
 	router.HandleFunc("/organizations", getOrganizations).Methods("GET")
+	router.HandleFunc("/organizations/{oldName:.+}", renameOrganization).Methods("PUT")
 
 	// General CRUD routes
 	router.HandleFunc("/students", getStudents).Methods("GET")
 	router.HandleFunc("/students", insertStudent).Methods("POST")
+	router.HandleFunc("/students", replaceStudents).Methods("PUT")
 
 	// Parameterized routes LAST (these will match anything)
+	router.HandleFunc("/students/{id}", getStudent).Methods("GET")
 	router.HandleFunc("/students/{id}", updateStudent).Methods("PUT")
+	router.HandleFunc("/students/{id}", patchStudent).Methods("PATCH")
 	router.HandleFunc("/students/{id}", deleteStudent).Methods("DELETE")
+	router.HandleFunc("/students/{id}/gpa", patchStudentGPA).Methods("PATCH")
+	router.HandleFunc("/students/{id}/restore", restoreStudent).Methods("POST")
+	router.HandleFunc("/students/{id}/history", getStudentHistory).Methods("GET")
+	router.HandleFunc("/students/{id}/rank", getStudentRank).Methods("GET")
 
-	log.Println("Server running on http://localhost:8080")
-	http.ListenAndServe(":8080", router)
+	slog.Info("Server running", "addr", addr)
+	if err := http.ListenAndServe(addr, router); err != nil {
+		// fatal skips deferred calls, so close the DB ourselves first.
+		db.Close()
+		fatal("Server failed", "error", err)
+	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestDB points db at a fresh database in a temporary directory until t
+// finishes.
+func newTestDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	db = initDB()
+	organizations.invalidate()
+	t.Cleanup(func() { db.Close() })
+}
+
+// serve calls h with a request for target, sending body as JSON when it isn't
+// empty, and returns the recorded response.
+func serve(t *testing.T, h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// serveRoute is serve for handlers that read path variables: h is mounted at
+// the route template path, as main does, and target has to match it.
+func serveRoute(t *testing.T, path string, h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := mux.NewRouter()
+	router.HandleFunc(path, h).Methods(method)
+	return serve(t, router.ServeHTTP, method, target, body)
+}
+
+// decodeBody decodes rec's JSON body into v, failing t if it can't.
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// mustInsert creates a student through insertStudent and returns its ID.
+func mustInsert(t *testing.T, body string) int64 {
+	t.Helper()
+	rec := serve(t, insertStudent, http.MethodPost, "/students", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("insert %s: status %d, body %s", body, rec.Code, rec.Body)
+	}
+	var resp struct {
+		ID int64 `json:"id"`
+	}
+	decodeBody(t, rec, &resp)
+	return resp.ID
+}
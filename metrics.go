@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// HTTP metrics are labelled by route template ("/students/{id}") rather than
+// the raw path so per-ID requests don't each get their own series.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	studentsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "students_total",
+		Help: "Students that are not soft-deleted, refreshed periodically.",
+	})
+)
+
+// studentCountInterval is how often studentsGauge is refreshed.
+const studentCountInterval = 30 * time.Second
+
+// metricsMiddleware records the request counter and latency histogram.
+// Requests that matched no route are labelled "unmatched".
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// refreshStudentCount keeps studentsGauge current, updating it once right
+// away and then every interval. It runs for the life of the process.
+func refreshStudentCount(interval time.Duration) {
+	update := func() {
+		var n int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM students WHERE deleted_at IS NULL").Scan(&n); err != nil {
+			slog.Error("Student count refresh failed", "error", err)
+			return
+		}
+		studentsGauge.Set(float64(n))
+	}
+
+	update()
+	for range time.Tick(interval) {
+		update()
+	}
+}
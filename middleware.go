@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder wraps a ResponseWriter to remember the status code written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes through so streaming handlers still work behind the recorder.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// maxRequestIDLength bounds an X-Request-ID we'll accept from a client.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// requestIDMiddleware gives every request an ID for correlating logs across
+// services. An incoming X-Request-ID is kept if it looks sane, otherwise a
+// UUID is generated. The ID goes into the request context, where the log
+// handler picks it up (so log with the *Context slog functions), and back out
+// in the X-Request-ID response header.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID requestIDMiddleware stored in ctx, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts 1-maxRequestIDLength printable ASCII characters
+// without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// loggingMiddleware writes one structured access log entry per request:
+// method, path, status, duration and remote address. 5xx responses are
+// logged at error level so alerts can key on them.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// recoveryMiddleware turns a panicking handler into a JSON 500 instead of a
+// dropped connection, logging the panic with its stack trace. If the handler
+// had already started the response there's nothing clean left to send, so
+// the panic is only logged. http.ErrAbortHandler is re-raised as net/http
+// expects.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &headerTracker{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.ErrorContext(r.Context(), "Handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(p),
+				"stack", string(debug.Stack()),
+			)
+			if !rec.wroteHeader {
+				jsonError(w, http.StatusInternalServerError, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// headerTracker remembers whether the response has been started.
+type headerTracker struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (t *headerTracker) WriteHeader(status int) {
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *headerTracker) Write(b []byte) (int, error) {
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *headerTracker) Flush() {
+	t.wroteHeader = true
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CORS defaults, used when the matching CORS_* variable isn't set.
+const (
+	defaultCORSMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	defaultCORSHeaders = "Content-Type, Idempotency-Key, X-Request-ID"
+)
+
+// corsConfig is what corsMiddleware sends, read from the environment by
+// parseCORSConfig.
+type corsConfig struct {
+	origins     map[string]bool // allowed origins; "*" allows any
+	methods     string
+	headers     string
+	credentials bool
+}
+
+// parseCORSConfig reads CORS_ALLOWED_ORIGIN (a comma-separated allowlist,
+// default "*"), CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS and
+// CORS_ALLOW_CREDENTIALS. Browsers refuse credentialed responses with a
+// wildcard origin, so credentials need specific origins.
+func parseCORSConfig() (corsConfig, error) {
+	cfg := corsConfig{
+		origins: map[string]bool{},
+		methods: defaultCORSMethods,
+		headers: defaultCORSHeaders,
+	}
+
+	origins := os.Getenv("CORS_ALLOWED_ORIGIN")
+	if origins == "" {
+		origins = "*"
+	}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			cfg.origins[o] = true
+		}
+	}
+	if len(cfg.origins) == 0 {
+		return corsConfig{}, fmt.Errorf("invalid CORS_ALLOWED_ORIGIN %q: must list at least one origin", origins)
+	}
+
+	var err error
+	if cfg.methods, err = corsList("CORS_ALLOWED_METHODS", defaultCORSMethods); err != nil {
+		return corsConfig{}, err
+	}
+	if cfg.headers, err = corsList("CORS_ALLOWED_HEADERS", defaultCORSHeaders); err != nil {
+		return corsConfig{}, err
+	}
+
+	if v := os.Getenv("CORS_ALLOW_CREDENTIALS"); v != "" {
+		if cfg.credentials, err = strconv.ParseBool(v); err != nil {
+			return corsConfig{}, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS %q: must be true or false", v)
+		}
+	}
+	if cfg.credentials && cfg.origins["*"] {
+		return corsConfig{}, fmt.Errorf("CORS_ALLOW_CREDENTIALS=true needs CORS_ALLOWED_ORIGIN to list specific origins, not *")
+	}
+	return cfg, nil
+}
+
+// corsList reads a comma-separated CORS header value from env, tidying the
+// spacing, or returns def when it isn't set.
+func corsList(env, def string) (string, error) {
+	v := os.Getenv(env)
+	if v == "" {
+		return def, nil
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf("invalid %s %q: must list at least one value", env, v)
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// corsMiddleware lets the browser frontend call the API from another origin.
+// With a "*" allowlist (the default, and never with credentials) any origin
+// is allowed; otherwise the request's Origin is echoed back only if it's on
+// the list. Preflight OPTIONS requests are answered here with a 204.
+func corsMiddleware(cfg corsConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.origins["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin := r.Header.Get("Origin"); cfg.origins[origin] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					if cfg.credentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", cfg.methods)
+			w.Header().Set("Access-Control-Allow-Headers", cfg.headers)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After")
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// defaultMaxBodyBytes caps request bodies when MAX_BODY_BYTES isn't set.
+const defaultMaxBodyBytes = 10 << 20 // 10MB
+
+// maxBodyBytes reads MAX_BODY_BYTES, falling back to defaultMaxBodyBytes.
+func maxBodyBytes() (int64, error) {
+	v := os.Getenv("MAX_BODY_BYTES")
+	if v == "" {
+		return defaultMaxBodyBytes, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid MAX_BODY_BYTES %q: must be a positive integer", v)
+	}
+	return n, nil
+}
+
+// unlimitedBodyRoutes are route templates whose handlers stream the body
+// rather than hold it, so bodyLimitMiddleware leaves them alone.
+var unlimitedBodyRoutes = map[string]bool{
+	"/students/stream": true,
+}
+
+// bodyLimitMiddleware caps every request body at limit bytes so a huge upload
+// can't exhaust memory. Reads past the limit fail with *http.MaxBytesError,
+// which handlers turn into a 413 via bodyTooLarge.
+func bodyLimitMiddleware(limit int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && !unlimitedBodyRoutes[routeTemplate(r)] {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeMethods are the methods probed when building an Allow header.
+// OPTIONS is left out: the preflight catch-all route matches every path.
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// methodNotAllowedHandler answers requests whose path is routed but whose
+// method isn't, with a JSON 405 and an Allow header listing the methods that
+// would have matched. Because the OPTIONS catch-all matches every path, mux
+// also sends unknown paths here; those get notFound instead.
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range routeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			notFound(w, r)
+			return
+		}
+		allowed = append(allowed, http.MethodOptions)
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		jsonError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+	})
+}
+
+// notFound is the JSON replacement for mux's plain-text 404.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	jsonError(w, http.StatusNotFound, "not found")
+}
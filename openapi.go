@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the hand-written OpenAPI 3.0 description of the API. Keep
+// openapi.json in step with the routes in main.go.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func getOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// execer is the Exec half of *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// syncOrganizations makes the organizations table match students for the
+// organizations in names, which write paths pass after changing rows inside
+// their transaction: each name (compared case-insensitively) without an
+// organization gets one, and every student in those organizations gets its
+// organization_id and the stored spelling, so "chess club" is stored as
+// "Chess Club" once that spelling exists. Students whose name it respells
+// get updated_at bumped, so the change shows up in /students/changes, and an
+// audit entry. Students in other organizations aren't touched.
+//
+// organization_id is a foreign key in practice only: DuckDB can't add one to
+// an existing table, and the index behind it would make every later UPDATE
+// of the column fail (see initDB).
+func syncOrganizations(tx *sql.Tx, names []string) error {
+	seen := map[string]bool{}
+	var keys []interface{}
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	scope := " AND lower(organization_name) IN (" + placeholders(len(keys)) + ")"
+
+	if err := createOrganizations(tx, scope, keys); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`
+    SELECT s.id
+    FROM students s JOIN organizations o ON lower(s.organization_name) = lower(o.name)
+    WHERE s.organization_name != o.name`+scope, keys...)
+	if err != nil {
+		return err
+	}
+	var respelled []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		respelled = append(respelled, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return auditMutation(tx, "update", respelled, func() error {
+		return linkStudents(tx, scope, keys)
+	})
+}
+
+// backfillOrganizations is syncOrganizations for every organization at once,
+// without audit entries. initDB runs it at startup, which fills the table for
+// databases from before it existed and merges spellings that differ only in
+// case.
+func backfillOrganizations(q execer) error {
+	if err := createOrganizations(q, "", nil); err != nil {
+		return err
+	}
+	return linkStudents(q, "", nil)
+}
+
+// createOrganizations adds an organization for each organization_name that
+// lacks one, using the first spelling alphabetically. scope is an extra
+// condition on students, with args as its parameters.
+func createOrganizations(q execer, scope string, args []interface{}) error {
+	_, err := q.Exec(`
+    INSERT INTO organizations (name)
+    SELECT MIN(organization_name)
+    FROM students
+    WHERE organization_name IS NOT NULL AND organization_name != ''
+      AND lower(organization_name) NOT IN (SELECT lower(name) FROM organizations)`+scope+`
+    GROUP BY lower(organization_name)
+    ORDER BY 1
+    `, args...)
+	return err
+}
+
+// linkStudents points students at their organization and its spelling.
+// scope and args are as for createOrganizations.
+func linkStudents(q execer, scope string, args []interface{}) error {
+	_, err := q.Exec(`
+    UPDATE students
+    SET organization_id = o.id,
+        organization_name = o.name,
+        updated_at = CASE WHEN students.organization_name != o.name THEN now() ELSE students.updated_at END
+    FROM organizations o
+    WHERE lower(students.organization_name) = lower(o.name)
+      AND (students.organization_id IS DISTINCT FROM o.id OR students.organization_name != o.name)`+scope, args...)
+	return err
+}
+
+// orgNames resolves organization names to the spelling already stored in
+// organizations, for writes that echo rows back and need to know up front
+// what syncOrganizations will keep. A name seen earlier in the same write
+// resolves to its first spelling, so one batch can't create two variants.
+type orgNames struct {
+	q    queryRower
+	seen map[string]string // lowercased name -> stored spelling
+}
+
+func newOrgNames(q queryRower) *orgNames {
+	return &orgNames{q: q, seen: map[string]string{}}
+}
+
+// canonical returns the stored spelling of name, or name itself if it's new.
+func (o *orgNames) canonical(name string) (string, error) {
+	key := strings.ToLower(name)
+	if c, ok := o.seen[key]; ok {
+		return c, nil
+	}
+	var c string
+	err := o.q.QueryRow("SELECT name FROM organizations WHERE lower(name) = lower(?)", name).Scan(&c)
+	if err == sql.ErrNoRows {
+		c, err = name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	o.seen[key] = c
+	return c, nil
+}
+
+// names returns every spelling canonical has handed out, for the
+// syncOrganizations call that follows the write.
+func (o *orgNames) names() []string {
+	names := make([]string, 0, len(o.seen))
+	for _, c := range o.seen {
+		names = append(names, c)
+	}
+	return names
+}
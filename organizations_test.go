@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestSyncOrganizationsOnlyTouchesWrittenOrganizations(t *testing.T) {
+	newTestDB(t)
+	// Rows as an older version could leave them: no organization yet, and a
+	// spelling that differs from the one about to be written.
+	_, err := db.Exec(`
+    INSERT INTO students (id, name, age, gpa, organization_name, updated_at) VALUES
+      (1, 'Ann', 20, 3.0, 'chess club', TIMESTAMP '2020-01-01'),
+      (2, 'Bob', 20, 3.0, 'debate', TIMESTAMP '2020-01-01')
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syncStudentIDSeq(db); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, `{"name":"Cy","age":20,"gpa":3.0,"organization_name":"Chess Club"}`)
+
+	var org string
+	var changes int
+	err = db.QueryRow("SELECT organization_name, (SELECT COUNT(*) FROM audit_log WHERE student_id = 1 AND action = 'update') FROM students WHERE id = 1").Scan(&org, &changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if org != "Chess Club" || changes != 1 {
+		t.Errorf("Ann: organization %q with %d audit entries, want %q with 1", org, changes, "Chess Club")
+	}
+
+	var linked, touched bool
+	err = db.QueryRow("SELECT organization_id IS NOT NULL, updated_at != TIMESTAMP '2020-01-01' FROM students WHERE id = 2").Scan(&linked, &touched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked || touched {
+		t.Errorf("Bob, in another organization, was rewritten (linked %v, updated_at changed %v)", linked, touched)
+	}
+}
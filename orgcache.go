@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultOrgCacheTTL is used when ORG_CACHE_TTL isn't set.
+const defaultOrgCacheTTL = 30 * time.Second
+
+// orgCount is one organization and how many (non-deleted) students it has.
+type orgCount struct {
+	OrganizationName string `json:"organization_name"`
+	Count            int    `json:"count"`
+}
+
+// orgCache holds every organization with its count for getOrganizations,
+// which the filter UI calls on each page load. Entries are served until ttl
+// has passed or a write invalidates them; a ttl of 0 turns caching off.
+type orgCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	counts  []orgCount // biggest first, then by name
+	fetched time.Time
+}
+
+// organizations is the cache getOrganizations reads. main sets its ttl from
+// ORG_CACHE_TTL.
+var organizations = &orgCache{ttl: defaultOrgCacheTTL}
+
+// parseOrgCacheTTL reads ORG_CACHE_TTL as a Go duration ("30s", "2m"),
+// falling back to defaultOrgCacheTTL.
+func parseOrgCacheTTL() (time.Duration, error) {
+	v := os.Getenv("ORG_CACHE_TTL")
+	if v == "" {
+		return defaultOrgCacheTTL, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid ORG_CACHE_TTL %q: must be a non-negative duration such as 30s", v)
+	}
+	return d, nil
+}
+
+// get returns the cached counts, reloading them if they're stale. The lock is
+// held across the reload so concurrent misses share one query. Callers must
+// not modify the returned slice.
+func (c *orgCache) get() ([]orgCount, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts != nil && time.Since(c.fetched) < c.ttl {
+		return c.counts, nil
+	}
+
+	// Organizations without a current student are left out, as they were
+	// before organizations had a table of their own.
+	rows, err := db.Query(`
+    SELECT o.name, COUNT(*)
+    FROM organizations o
+    JOIN students s ON s.organization_id = o.id
+    WHERE s.deleted_at IS NULL
+    GROUP BY o.id, o.name
+    ORDER BY COUNT(*) DESC, o.name
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []orgCount{}
+	for rows.Next() {
+		var oc orgCount
+		if err := rows.Scan(&oc.OrganizationName, &oc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, oc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.counts = counts
+	c.fetched = time.Now()
+	return counts, nil
+}
+
+// invalidate drops the cached counts so the next get reloads them.
+func (c *orgCache) invalidate() {
+	c.mu.Lock()
+	c.counts = nil
+	c.mu.Unlock()
+}
+
+// orgCacheMiddleware invalidates the organization cache after every
+// successful write request. It runs once the handler (and its transaction)
+// has finished, so a reload can't pick up data from before the commit.
+func orgCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if rec.status < 400 {
+				organizations.invalidate()
+			}
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+// Defaults for RATE_LIMIT_RPS and RATE_LIMIT_BURST.
+const (
+	defaultRateLimitRPS   = 100
+	defaultRateLimitBurst = 200
+)
+
+// rateLimitIdleTTL is how long a client's limiter is kept after its last
+// request. By then its bucket has refilled, so dropping it loses nothing.
+const rateLimitIdleTTL = 5 * time.Minute
+
+// rateLimitExempt lists the route templates rateLimitMiddleware never limits,
+// so a busy client can't make the server look unhealthy.
+var rateLimitExempt = map[string]bool{
+	"/healthz": true,
+}
+
+// rateLimitConfig is the per-client request rate; a zero rps turns limiting
+// off.
+type rateLimitConfig struct {
+	rps   rate.Limit
+	burst int
+}
+
+// parseRateLimit reads RATE_LIMIT_RPS (requests per second per client,
+// default 100, 0 disables) and RATE_LIMIT_BURST (requests a client can make
+// at once before the rate applies, default 200).
+func parseRateLimit() (rateLimitConfig, error) {
+	cfg := rateLimitConfig{rps: defaultRateLimitRPS, burst: defaultRateLimitBurst}
+	if v := os.Getenv("RATE_LIMIT_RPS"); v != "" {
+		rps, err := strconv.ParseFloat(v, 64)
+		if err != nil || rps < 0 || math.IsInf(rps, 0) || math.IsNaN(rps) {
+			return rateLimitConfig{}, fmt.Errorf("invalid RATE_LIMIT_RPS %q: must be a non-negative number", v)
+		}
+		cfg.rps = rate.Limit(rps)
+	}
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		burst, err := strconv.Atoi(v)
+		if err != nil || burst < 1 {
+			return rateLimitConfig{}, fmt.Errorf("invalid RATE_LIMIT_BURST %q: must be a positive integer", v)
+		}
+		cfg.burst = burst
+	}
+	return cfg, nil
+}
+
+// clientLimiters holds one token bucket per client key.
+type clientLimiters struct {
+	cfg rateLimitConfig
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastPrune time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// reserve takes a token from key's bucket and returns how long the client
+// has to wait before one is available, or 0 if the request can go ahead. A
+// request that has to wait doesn't use up a token.
+func (c *clientLimiters) reserve(key string, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > rateLimitIdleTTL {
+		for k, cl := range c.clients {
+			if now.Sub(cl.lastSeen) > rateLimitIdleTTL {
+				delete(c.clients, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	cl, ok := c.clients[key]
+	if !ok {
+		cl = &clientLimiter{limiter: rate.NewLimiter(c.cfg.rps, c.cfg.burst)}
+		c.clients[key] = cl
+	}
+	cl.lastSeen = now
+
+	res := cl.limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay > 0 {
+		res.CancelAt(now)
+	}
+	return delay
+}
+
+// rateLimitKey identifies the client behind r: the API key when the request
+// carries the configured one (so admin calls get their own bucket), otherwise
+// the connection's IP. Unrecognized keys aren't used, or a client could dodge
+// the limit by sending a new one with every request. X-Forwarded-For isn't
+// trusted either, for the same reason.
+func rateLimitKey(r *http.Request, apiKey string) string {
+	if apiKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(apiKey)) == 1 {
+		return "api-key"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitMiddleware caps each client at cfg's rate so one runaway script
+// can't monopolize the single database connection. Requests over the limit
+// get a 429 with a Retry-After header (whole seconds, rounded up). apiKey is
+// ADMIN_API_KEY, or "" if it isn't set.
+func rateLimitMiddleware(cfg rateLimitConfig, apiKey string) mux.MiddlewareFunc {
+	limiters := &clientLimiters{cfg: cfg, clients: map[string]*clientLimiter{}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.rps == 0 || rateLimitExempt[routeTemplate(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if delay := limiters.reserve(rateLimitKey(r, apiKey), time.Now()); delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				jsonError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// readOnly is the maintenance switch: while it's set every write request is
+// refused with a 503 and reads carry on. main sets it from READ_ONLY and
+// PUT /read-only (admins only) flips it at runtime.
+var readOnly atomic.Bool
+
+// readOnlyExempt lists the route templates readOnlyMiddleware lets through
+// even though they aren't GETs: the switch itself, and export jobs, which only
+// read the database.
+var readOnlyExempt = map[string]bool{
+	"/read-only":            true,
+	"/students/export-jobs": true,
+}
+
+// parseReadOnly reads READ_ONLY ("true"/"false", default false).
+func parseReadOnly() (bool, error) {
+	v := os.Getenv("READ_ONLY")
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid READ_ONLY %q: must be true or false", v)
+	}
+	return b, nil
+}
+
+// readOnlyMiddleware answers every request other than GET, HEAD and OPTIONS
+// with a 503 while read-only mode is on.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if readOnly.Load() && !readOnlyExempt[routeTemplate(r)] {
+				w.Header().Set("Retry-After", "60")
+				jsonError(w, http.StatusServiceUnavailable, "read-only mode")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeTemplate returns the path template of the route mux matched, or "".
+func routeTemplate(r *http.Request) string {
+	if cur := mux.CurrentRoute(r); cur != nil {
+		if tmpl, err := cur.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return ""
+}
+
+// getReadOnly reports whether read-only mode is on.
+func getReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"read_only": readOnly.Load()})
+}
+
+// hasAPIKey reports whether r carries apiKey in X-API-Key, answering 401 when
+// it doesn't. The comparison takes the same time whatever the header holds.
+func hasAPIKey(w http.ResponseWriter, r *http.Request, apiKey string) bool {
+	got := r.Header.Get("X-API-Key")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(apiKey)) != 1 {
+		jsonError(w, http.StatusUnauthorized, "Invalid or missing X-API-Key")
+		return false
+	}
+	return true
+}
+
+// setReadOnly returns the handler for PUT /read-only, which turns read-only
+// mode on or off, e.g. around a backup. Writes already in flight when it's
+// switched on are allowed to finish. The request must carry apiKey in
+// X-API-Key; main only registers the route when ADMIN_API_KEY is set.
+func setReadOnly(apiKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasAPIKey(w, r, apiKey) {
+			return
+		}
+
+		var body struct {
+			ReadOnly *bool `json:"read_only"`
+		}
+		if !requireJSON(w, r) {
+			return
+		}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			jsonError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+			return
+		}
+		if body.ReadOnly == nil {
+			jsonError(w, http.StatusBadRequest, "read_only is required")
+			return
+		}
+
+		if readOnly.Swap(*body.ReadOnly) != *body.ReadOnly {
+			slog.WarnContext(r.Context(), "Read-only mode changed", "read_only", *body.ReadOnly)
+		}
+		getReadOnly(w, r)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetReadOnlyNeedsAPIKey(t *testing.T) {
+	t.Cleanup(func() { readOnly.Store(false) })
+	h := setReadOnly("secret")
+
+	for _, tc := range []struct {
+		key  string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/read-only", strings.NewReader(`{"read_only":true}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("key %q: status %d, want %d", tc.key, rec.Code, tc.code)
+		}
+		if got := readOnly.Load(); got != (tc.code == http.StatusOK) {
+			t.Errorf("key %q: read-only mode is %v after the request", tc.key, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDBMaxRetries is how many times a write is retried after a transient
+// database error when DB_MAX_RETRIES isn't set.
+const defaultDBMaxRetries = 3
+
+// retryBaseDelay is the wait before the first retry; it doubles each attempt.
+const retryBaseDelay = 50 * time.Millisecond
+
+// dbMaxRetries is set from DB_MAX_RETRIES at startup.
+var dbMaxRetries = defaultDBMaxRetries
+
+// errRetriesExhausted marks a write that kept hitting transient errors after
+// every retry; handlers answer it with a 503.
+var errRetriesExhausted = errors.New("database busy, please retry")
+
+// statusError is returned from inside a retried write to fail the request
+// with a specific status (e.g. a 409 for a duplicate email). It is never
+// retried.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// parseDBMaxRetries reads DB_MAX_RETRIES, falling back to defaultDBMaxRetries.
+// 0 turns retrying off.
+func parseDBMaxRetries() (int, error) {
+	v := os.Getenv("DB_MAX_RETRIES")
+	if v == "" {
+		return defaultDBMaxRetries, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid DB_MAX_RETRIES %q: must be a non-negative integer", v)
+	}
+	return n, nil
+}
+
+// isTransient reports whether err is a lock or write-write conflict that is
+// likely to succeed if the transaction is simply run again.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "conflict") ||
+		strings.Contains(msg, "could not set lock") ||
+		strings.Contains(msg, "database is locked")
+}
+
+// withRetry runs fn, running it again with exponential backoff while it fails
+// with a transient error, up to dbMaxRetries extra attempts. fn must start and
+// finish its own transaction so each attempt begins clean. When the retries
+// run out the error wraps errRetriesExhausted.
+func withRetry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var se *statusError
+		if err == nil || errors.As(err, &se) || !isTransient(err) {
+			return err
+		}
+		if attempt >= dbMaxRetries {
+			return fmt.Errorf("%w: %v", errRetriesExhausted, err)
+		}
+
+		slog.WarnContext(ctx, "Transient database error, retrying", "attempt", attempt+1, "delay_ms", delay.Milliseconds(), "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// writeTxError turns an error from a retried write into a JSON response:
+// 503 when the retries ran out, the carried status for a statusError and 500
+// for anything else.
+func writeTxError(w http.ResponseWriter, err error) {
+	var se *statusError
+	switch {
+	case errors.Is(err, errRetriesExhausted):
+		w.Header().Set("Retry-After", "1")
+		jsonError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.As(err, &se):
+		jsonError(w, se.status, se.msg)
+	default:
+		jsonError(w, http.StatusInternalServerError, err.Error())
+	}
+}
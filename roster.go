@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// rosterResult is what replaceStudents did, one count per action.
+type rosterResult struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+// replaceStudents makes the roster exactly the posted array of students (the
+// bulk insert format), in one transaction, so a sync tool can declare the
+// whole table without deleting everything and reinserting it. Each element is
+// matched to an existing student by student_number, or failing that by name
+// (preferring live students, and only among students without a student
+// number when the element has one). Matched students are overwritten if
+// anything differs, and restored if they were soft-deleted; unmatched
+// elements are inserted; students left unmatched are soft-deleted. An empty
+// array is refused rather than deleting everyone.
+func replaceStudents(w http.ResponseWriter, r *http.Request) {
+	var students []bulkStudent
+	if !requireJSON(w, r) {
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&students); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if len(students) == 0 {
+		jsonError(w, http.StatusBadRequest, "No students provided")
+		return
+	}
+
+	numbers := map[string]int{} // student number -> row that uses it
+	for i := range students {
+		s := &students[i]
+		s.index = i
+		err := prepareBulkStudent(s)
+		if err == nil && s.Number != "" {
+			if j, dup := numbers[s.Number]; dup {
+				err = &fieldError{"student_number", fmt.Sprintf("student number %s is also used by row %d", s.Number, j)}
+			}
+			numbers[s.Number] = i
+		}
+		if err != nil {
+			fe := err.(*fieldError)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Row %d: %s", i, fe.Message),
+				"index": i,
+				"field": fe.Field,
+			})
+			return
+		}
+	}
+
+	var res rosterResult
+	err := withRetry(r.Context(), func() error {
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			return fmt.Errorf("Database error: Could not start transaction: %w", err)
+		}
+		defer tx.Rollback() // no-op once committed
+
+		res, err = applyRoster(tx, students)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			slog.ErrorContext(r.Context(), "Roster replace commit failed", "error", err)
+			return fmt.Errorf("Transaction commit failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Roster replace failed", "error", err)
+		writeTxError(w, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Roster replaced", "inserted", res.Inserted, "updated", res.Updated, "deleted", res.Deleted, "unchanged", res.Unchanged)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// matchRoster pairs each element of students with the ID of the existing
+// student it replaces, or 0 for one to insert, and returns the live students
+// nobody claimed. Student numbers are matched first, against deleted students
+// too since their numbers are still taken, and only then names, live students
+// before deleted ones and lowest ID first, so a name can't claim a student
+// that a later element names by number.
+func matchRoster(tx *sql.Tx, students []bulkStudent) ([]int64, []int64, error) {
+	rows, err := tx.Query("SELECT id, name, student_number, deleted_at IS NULL FROM students ORDER BY id")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	byNumber := map[string]int64{}
+	byName := map[string][]int64{}
+	deletedByName := map[string][]int64{}
+	hasNumber := map[int64]bool{}
+	var live []int64
+	for rows.Next() {
+		var id int64
+		var name, number sql.NullString
+		var alive bool
+		if err := rows.Scan(&id, &name, &number, &alive); err != nil {
+			return nil, nil, err
+		}
+		if number.Valid {
+			byNumber[number.String] = id
+			hasNumber[id] = true
+		}
+		if alive {
+			live = append(live, id)
+			byName[name.String] = append(byName[name.String], id)
+		} else {
+			deletedByName[name.String] = append(deletedByName[name.String], id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for name, ids := range deletedByName {
+		byName[name] = append(byName[name], ids...)
+	}
+
+	matches := make([]int64, len(students))
+	claimed := map[int64]bool{}
+	for i, s := range students {
+		if id, ok := byNumber[s.Number]; ok && s.Number != "" {
+			matches[i] = id
+			claimed[id] = true
+		}
+	}
+	for i, s := range students {
+		if matches[i] != 0 {
+			continue
+		}
+		for _, id := range byName[s.Name] {
+			if !claimed[id] && (s.Number == "" || !hasNumber[id]) {
+				matches[i] = id
+				claimed[id] = true
+				break
+			}
+		}
+	}
+
+	var unclaimed []int64
+	for _, id := range live {
+		if !claimed[id] {
+			unclaimed = append(unclaimed, id)
+		}
+	}
+	return matches, unclaimed, nil
+}
+
+// applyRoster does replaceStudents' writes on tx, audited, and checks email
+// and student number uniqueness once they're all done, so a roster that
+// moves a value from one student to another goes through. The caller owns
+// tx.
+func applyRoster(tx *sql.Tx, students []bulkStudent) (rosterResult, error) {
+	var res rosterResult
+	matches, unclaimed, err := matchRoster(tx, students)
+	if err != nil {
+		return res, err
+	}
+
+	var existing []int64
+	for _, id := range matches {
+		if id != 0 {
+			existing = append(existing, id)
+		}
+	}
+	before, err := snapshotStudents(tx, append(existing, unclaimed...))
+	if err != nil {
+		return res, err
+	}
+
+	var inserted, updated []int64
+	ids := make([]int64, len(students))
+	orgs := newOrgNames(tx)
+	now := time.Now().UTC()
+	for i, s := range students {
+		if s.Org, err = orgs.canonical(s.Org); err != nil {
+			return res, err
+		}
+		email, number := nullIfEmpty(s.Email), nullIfEmpty(s.Number)
+
+		if id := matches[i]; id != 0 {
+			// Only rows that actually differ are written, so an unchanged
+			// student keeps its updated_at and stays out of /students/changes.
+			result, err := tx.Exec(`
+    UPDATE students
+    SET name = ?, age = ?, gpa = ?, organization_name = ?, email = ?, student_number = ?,
+        updated_at = ?, deleted_at = NULL
+    WHERE id = ?
+      AND (name IS DISTINCT FROM ? OR age IS DISTINCT FROM ? OR gpa IS DISTINCT FROM CAST(? AS FLOAT)
+           OR organization_name IS DISTINCT FROM ? OR email IS DISTINCT FROM ?
+           OR student_number IS DISTINCT FROM ? OR deleted_at IS NOT NULL)
+    `, s.Name, s.Age, s.GPA, s.Org, email, number, now, id,
+				s.Name, s.Age, s.GPA, s.Org, email, number)
+			if err != nil {
+				return res, fmt.Errorf("Row %d: update failed: %w", i, err)
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				updated = append(updated, id)
+			} else {
+				res.Unchanged++
+			}
+			ids[i] = id
+			continue
+		}
+
+		id, err := nextStudentID(tx)
+		if err != nil {
+			return res, err
+		}
+		_, err = tx.Exec(`
+    INSERT INTO students (id, name, age, gpa, organization_name, email, student_number, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, id, s.Name, s.Age, s.GPA, s.Org, email, number, now, now)
+		if err != nil {
+			return res, fmt.Errorf("Row %d: insert failed: %w", i, err)
+		}
+		inserted = append(inserted, id)
+		ids[i] = id
+	}
+
+	if len(unclaimed) > 0 {
+		args := make([]interface{}, len(unclaimed))
+		for i, id := range unclaimed {
+			args[i] = id
+		}
+		_, err := tx.Exec("UPDATE students SET deleted_at = now(), updated_at = now() WHERE id IN ("+placeholders(len(unclaimed))+")", args...)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	for i, s := range students {
+		if taken, err := emailTaken(tx, s.Email, ids[i]); err != nil {
+			return res, err
+		} else if taken {
+			return res, &statusError{http.StatusConflict, fmt.Sprintf("Row %d: a student with email %s already exists", i, s.Email)}
+		}
+		if taken, err := studentNumberTaken(tx, s.Number, ids[i]); err != nil {
+			return res, err
+		} else if taken {
+			return res, &statusError{http.StatusConflict, fmt.Sprintf("Row %d: a student with student number %s already exists", i, s.Number)}
+		}
+	}
+
+	if err := syncOrganizations(tx, orgs.names()); err != nil {
+		return res, err
+	}
+	after, err := snapshotStudents(tx, append(ids, unclaimed...))
+	if err == nil {
+		err = recordAudit(tx, "insert", inserted, before, after)
+	}
+	if err == nil {
+		err = recordAudit(tx, "update", updated, before, after)
+	}
+	if err == nil {
+		err = recordAudit(tx, "delete", unclaimed, before, after)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	res.Inserted, res.Updated, res.Deleted = len(inserted), len(updated), len(unclaimed)
+	return res, nil
+}
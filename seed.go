@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// seedStudents loads SEED_FILE, a JSON array in the POST /students/bulk
+// format, into an empty students table so demos and local dev start with
+// data. Rows go through the bulk insert path in one transaction, so either
+// the whole file loads or none of it does. A missing, malformed or invalid
+// file is logged and skipped; startup carries on with an empty table.
+func seedStudents(conn *sql.DB) {
+	path := os.Getenv("SEED_FILE")
+	if path == "" {
+		return
+	}
+
+	var existing int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM students").Scan(&existing); err != nil {
+		slog.Warn("Seeding skipped: could not count students", "error", err)
+		return
+	}
+	if existing > 0 {
+		slog.Info("Seeding skipped: students table is not empty", "path", path)
+		return
+	}
+
+	students, err := readSeedFile(path)
+	if err != nil {
+		slog.Warn("Seeding skipped, starting empty", "path", path, "error", err)
+		return
+	}
+	if len(students) == 0 {
+		return
+	}
+
+	created, _, err := insertBulkChunk(context.Background(), conn, students)
+	if err != nil {
+		slog.Warn("Seeding failed, starting empty", "path", path, "error", err)
+		return
+	}
+	slog.Info("Seeded students", "path", path, "count", len(created))
+}
+
+// readSeedFile decodes and validates every row of a seed file.
+func readSeedFile(path string) ([]bulkStudent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var students []bulkStudent
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&students); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	for i := range students {
+		students[i].index = i
+		if err := prepareBulkStudent(&students[i]); err != nil {
+			return nil, fmt.Errorf("row %d: %v", i, err)
+		}
+	}
+	return students, nil
+}
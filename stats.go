@@ -0,0 +1,331 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// getStudentStats returns summary numbers for the dashboard in a single
+// aggregate query. Averages are rounded to two decimals; on an empty table
+// the averages/extremes come back null.
+func getStudentStats(w http.ResponseWriter, r *http.Request) {
+	var total, orgs int
+	var avgGPA, minGPA, maxGPA, avgAge, minAge, maxAge sql.NullFloat64
+	err := db.QueryRow(`
+    SELECT COUNT(*),
+           ROUND(AVG(gpa), 2), MIN(gpa), MAX(gpa),
+           ROUND(AVG(age), 2), MIN(age), MAX(age),
+           COUNT(DISTINCT organization_name)
+    FROM students
+    WHERE deleted_at IS NULL
+    `).Scan(&total, &avgGPA, &minGPA, &maxGPA, &avgAge, &minAge, &maxAge, &orgs)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Stats query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":         total,
+		"avg_gpa":       nullableFloat(avgGPA),
+		"min_gpa":       nullableFloat(minGPA),
+		"max_gpa":       nullableFloat(maxGPA),
+		"avg_age":       nullableFloat(avgAge),
+		"min_age":       nullableFloat(minAge),
+		"max_age":       nullableFloat(maxAge),
+		"organizations": orgs,
+	})
+}
+
+// getStatsByOrganization returns the student count and average GPA for each
+// organization, largest first.
+func getStatsByOrganization(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+    SELECT organization_name, COUNT(*), ROUND(AVG(gpa), 2)
+    FROM students
+    WHERE deleted_at IS NULL
+    GROUP BY organization_name
+    ORDER BY COUNT(*) DESC, organization_name
+    `)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Org stats query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	type orgStats struct {
+		OrganizationName interface{} `json:"organization_name"`
+		Count            int         `json:"count"`
+		AvgGPA           interface{} `json:"avg_gpa"`
+	}
+
+	stats := []orgStats{}
+	for rows.Next() {
+		var s orgStats
+		var org sql.NullString
+		var avg sql.NullFloat64
+		if err := rows.Scan(&org, &s.Count, &avg); err != nil {
+			slog.ErrorContext(r.Context(), "Scan failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.OrganizationName = nullableString(org)
+		s.AvgGPA = nullableFloat(avg)
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// getAgeHistogram counts students per age bucket of ?bucket= years (default
+// 5), e.g. {"range": "20-24", "count": 7}. Buckets start at multiples of the
+// width and only non-empty ones are returned, youngest first.
+func getAgeHistogram(w http.ResponseWriter, r *http.Request) {
+	bucket := 5
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 120 {
+			jsonError(w, http.StatusBadRequest, "bucket must be an integer between 1 and 120")
+			return
+		}
+		bucket = n
+	}
+
+	rows, err := db.Query(`
+    SELECT (age // ?) * ? AS lo, COUNT(*)
+    FROM students
+    WHERE age IS NOT NULL AND deleted_at IS NULL
+    GROUP BY lo
+    ORDER BY lo
+    `, bucket, bucket)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Age histogram query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	type ageBucket struct {
+		Range string `json:"range"`
+		Count int    `json:"count"`
+	}
+	buckets := []ageBucket{}
+	for rows.Next() {
+		var lo, count int
+		if err := rows.Scan(&lo, &count); err != nil {
+			slog.ErrorContext(r.Context(), "Scan failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		buckets = append(buckets, ageBucket{Range: fmt.Sprintf("%d-%d", lo, lo+bucket-1), Count: count})
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buckets)
+}
+
+// getGPAHistogram counts students per GPA band of ?width= (default 0.5),
+// returning every band from 0 to 4.0, empty ones included, so a chart gets a
+// fixed x-axis. Bands include their lower bound and exclude their upper one,
+// except the top band, which also takes a perfect 4.0. Bucketing is done on
+// GPA in hundredths so float32 storage (3.6 is kept as 3.5999999) can't push
+// a value into the band below.
+func getGPAHistogram(w http.ResponseWriter, r *http.Request) {
+	width := 0.5
+	if v := r.URL.Query().Get("width"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0.01 || f > 4.0 || math.Abs(f*100-math.Round(f*100)) > 1e-9 {
+			jsonError(w, http.StatusBadRequest, "width must be a number between 0.01 and 4.0 with at most two decimals")
+			return
+		}
+		width = f
+	}
+	widthHundredths := int(math.Round(width * 100))
+	bands := (400 + widthHundredths - 1) / widthHundredths
+
+	rows, err := db.Query(`
+    SELECT LEAST(CAST(ROUND(gpa * 100) AS INTEGER) // ?, ?) AS band, COUNT(*)
+    FROM students
+    WHERE gpa IS NOT NULL AND deleted_at IS NULL
+    GROUP BY band
+    `, widthHundredths, bands-1)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "GPA histogram query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	counts := make([]int, bands)
+	for rows.Next() {
+		var band, count int
+		if err := rows.Scan(&band, &count); err != nil {
+			slog.ErrorContext(r.Context(), "Scan failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if band >= 0 && band < bands {
+			counts[band] = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type gpaBand struct {
+		Range string  `json:"range"`
+		Min   float64 `json:"min"`
+		Max   float64 `json:"max"`
+		Count int     `json:"count"`
+	}
+	result := make([]gpaBand, bands)
+	for i := range result {
+		lo := float64(i*widthHundredths) / 100
+		hi := math.Min(float64((i+1)*widthHundredths)/100, 4.0)
+		result[i] = gpaBand{
+			Range: strconv.FormatFloat(lo, 'f', -1, 64) + "-" + strconv.FormatFloat(hi, 'f', -1, 64),
+			Min:   lo,
+			Max:   hi,
+			Count: counts[i],
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// minPercentileStudents is the fewest students with a GPA an organization
+// needs before getGPAPercentiles reports percentiles; below it they're null.
+const minPercentileStudents = 4
+
+// gpaPercentiles are the percentiles getGPAPercentiles reports.
+var gpaPercentiles = []struct {
+	key      string
+	fraction float64
+}{{"p25", 0.25}, {"p50", 0.5}, {"p75", 0.75}, {"p90", 0.9}}
+
+// getGPAPercentiles returns the 25th, 50th, 75th and 90th percentile GPA of
+// ?organization=, interpolated between students (quantile_cont) and rounded
+// to two decimals. The name is matched case-insensitively against the
+// organizations table; an unknown one is 404. With fewer than
+// minPercentileStudents GPAs on record the percentiles are null and
+// insufficient_data is true, since a "90th percentile" of two students
+// means little.
+func getGPAPercentiles(w http.ResponseWriter, r *http.Request) {
+	org := r.URL.Query().Get("organization")
+	if org == "" {
+		jsonError(w, http.StatusBadRequest, "organization is required")
+		return
+	}
+
+	fractions := make([]string, len(gpaPercentiles))
+	for i, p := range gpaPercentiles {
+		fractions[i] = strconv.FormatFloat(p.fraction, 'f', -1, 64)
+	}
+	var name string
+	var count int
+	var values interface{}
+	err := db.QueryRow(`
+    SELECT o.name, COUNT(s.gpa), quantile_cont(s.gpa::DOUBLE, [`+strings.Join(fractions, ", ")+`])
+    FROM organizations o
+    LEFT JOIN students s ON s.organization_id = o.id AND s.deleted_at IS NULL
+    WHERE lower(o.name) = lower(?)
+    GROUP BY o.name
+    `, org).Scan(&name, &count, &values)
+	if err == sql.ErrNoRows {
+		jsonError(w, http.StatusNotFound, "Organization not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "GPA percentile query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// values is NULL when nobody has a GPA.
+	list, _ := values.([]interface{})
+	insufficient := count < minPercentileStudents || len(list) != len(gpaPercentiles)
+	percentiles := map[string]interface{}{}
+	for i, p := range gpaPercentiles {
+		percentiles[p.key] = nil
+		if !insufficient {
+			percentiles[p.key] = math.Round(list[i].(float64)*100) / 100
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"organization_name": name,
+		"count":             count,
+		"insufficient_data": insufficient,
+		"percentiles":       percentiles,
+	})
+}
+
+// getStudentRank returns where a student's GPA ranks within their
+// organization: rank 1 is the highest GPA, and tied students share a rank.
+// percentile is the share of the organization's other students ranked below
+// this one (100 for the top, 0 for the bottom). Students without an
+// organization are ranked among each other. Soft-deleted students are
+// neither ranked nor counted, so asking for one is a 404.
+func getStudentRank(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var rank, outOf int
+	var org sql.NullString
+	var gpa, percentRank sql.NullFloat64
+	err = db.QueryRow(`
+    SELECT rank, out_of, percent_rank, organization_name, gpa
+    FROM (
+        SELECT id, organization_name, gpa,
+               RANK() OVER (ORDER BY gpa DESC NULLS LAST) AS rank,
+               PERCENT_RANK() OVER (ORDER BY gpa DESC NULLS LAST) AS percent_rank,
+               COUNT(*) OVER () AS out_of
+        FROM students
+        WHERE deleted_at IS NULL
+          AND organization_name IS NOT DISTINCT FROM (SELECT organization_name FROM students WHERE id = ?)
+    )
+    WHERE id = ?
+    `, id, id).Scan(&rank, &outOf, &percentRank, &org, &gpa)
+	if err == sql.ErrNoRows {
+		jsonError(w, http.StatusNotFound, "Student not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rank query failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":                id,
+		"organization_name": nullableString(org),
+		"gpa":               nullableFloat(gpa),
+		"rank":              rank,
+		"out_of":            outOf,
+		"percentile":        math.Round((1-percentRank.Float64)*10000) / 100,
+	})
+}
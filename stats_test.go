@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRankWithoutOrganization(t *testing.T) {
+	newTestDB(t)
+	// Rows from before organizations were required can have none.
+	_, err := db.Exec(`
+    INSERT INTO students (id, name, age, gpa, organization_name) VALUES
+      (1, 'Ann', 20, 3.5, NULL),
+      (2, 'Bob', 20, 3.0, NULL),
+      (3, 'Cy', 20, 4.0, 'Chess Club')
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveRoute(t, "/students/{id}/rank", getStudentRank, http.MethodGet, "/students/2/rank", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Rank  int `json:"rank"`
+		OutOf int `json:"out_of"`
+	}
+	decodeBody(t, rec, &got)
+	if got.Rank != 2 || got.OutOf != 2 {
+		t.Errorf("rank %d of %d, want 2 of 2", got.Rank, got.OutOf)
+	}
+
+	rec = serveRoute(t, "/students/{id}/rank", getStudentRank, http.MethodGet, "/students/99/rank", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing student: status %d, want 404", rec.Code)
+	}
+}
+
+func TestTopStudentsOrganizationIgnoresCase(t *testing.T) {
+	newTestDB(t)
+	ann := mustInsert(t, `{"name":"Ann","age":20,"gpa":3.5,"organization_name":"Chess Club"}`)
+	mustInsert(t, `{"name":"Bob","age":20,"gpa":3.9,"organization_name":"Debate Society"}`)
+
+	rec := serve(t, getTopStudents, http.MethodGet, "/students/top?organization=chess+club", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var students []struct {
+		ID int64 `json:"id"`
+	}
+	decodeBody(t, rec, &students)
+	if len(students) != 1 || students[0].ID != ann {
+		t.Errorf("got %+v, want only Ann (id %d)", students, ann)
+	}
+}
+
+func TestAtRiskOrganizationIgnoresCase(t *testing.T) {
+	newTestDB(t)
+	ann := mustInsert(t, `{"name":"Ann","age":20,"gpa":1.5,"organization_name":"Chess Club"}`)
+	mustInsert(t, `{"name":"Bob","age":20,"gpa":1.0,"organization_name":"Debate Society"}`)
+
+	rec := serve(t, getAtRiskStudents, http.MethodGet, "/students/at-risk?organization=CHESS+CLUB", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var students []struct {
+		ID int64 `json:"id"`
+	}
+	decodeBody(t, rec, &students)
+	if len(students) != 1 || students[0].ID != ann {
+		t.Errorf("got %+v, want only Ann (id %d)", students, ann)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// maxStreamLineBytes caps one NDJSON line. The body as a whole is exempt from
+// MAX_BODY_BYTES since it's never held in memory.
+const maxStreamLineBytes = 1 << 20 // 1MB
+
+// streamInsertStudents inserts students from a newline-delimited JSON body
+// (one bulk insert element per line, blank lines ignored) as it's read, so
+// memory stays flat however many rows arrive. Everything goes in one
+// transaction: the first bad line rolls the whole load back with a 400 naming
+// the line. Lines are written bulkChunkSize at a time through insertBulkRows,
+// which checks each row's email and student number as /students/bulk does,
+// and each batch is audited as it's written. The database is held for the
+// whole upload, so other requests wait behind it. Unlike the other writes it
+// isn't retried on a transient error, because the body can't be read twice.
+func streamInsertStudents(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-ndjson" {
+		jsonError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/x-ndjson")
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Database error: Could not start transaction")
+		return
+	}
+	defer tx.Rollback() // no-op once committed
+
+	// lineError answers with a 400 pointing at the offending line.
+	lineError := func(line int, field, msg string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		resp := map[string]interface{}{
+			"error": fmt.Sprintf("Line %d: %s", line, msg),
+			"line":  line,
+		}
+		if field != "" {
+			resp["field"] = field
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+
+	inserted := 0
+	pending := make([]bulkStudent, 0, bulkChunkSize) // read but not yet written
+	// flush writes and audits pending, answering with the error if it can't.
+	flush := func() bool {
+		if len(pending) == 0 {
+			return true
+		}
+		created, _, status, err := insertBulkRows(tx, pending, false)
+		if err == nil {
+			err = auditInserted(tx, created)
+			status = http.StatusInternalServerError
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Stream insert failed", "first_line", pending[0].index, "error", err)
+			jsonError(w, status, err.Error())
+			return false
+		}
+		inserted += len(created)
+		pending = pending[:0]
+		return true
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineBytes)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var s bulkStudent
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			lineError(line, "", "Invalid JSON: "+err.Error())
+			return
+		}
+		if err := prepareBulkStudent(&s); err != nil {
+			fe := err.(*fieldError)
+			lineError(line, fe.Field, fe.Message)
+			return
+		}
+		s.index = line
+		pending = append(pending, s)
+
+		if len(pending) >= bulkChunkSize && !flush() {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			lineError(line+1, "", fmt.Sprintf("line is longer than %d bytes", maxStreamLineBytes))
+			return
+		}
+		jsonError(w, http.StatusBadRequest, "Reading body failed: "+err.Error())
+		return
+	}
+	if !flush() {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(r.Context(), "Transaction commit failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, "Transaction commit failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Stream insert successful",
+		"inserted": inserted,
+	})
+}
+
+// auditInserted records an insert audit entry for each of students.
+func auditInserted(tx *sql.Tx, students []Student) error {
+	ids := make([]int64, len(students))
+	for i, s := range students {
+		ids[i] = s.ID
+	}
+	after, err := snapshotStudents(tx, ids)
+	if err != nil {
+		return err
+	}
+	return recordAudit(tx, "insert", ids, nil, after)
+}
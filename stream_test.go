@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stream posts body to streamInsertStudents as NDJSON.
+func stream(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/students/stream", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	streamInsertStudents(rec, req)
+	return rec
+}
+
+// storedStudents counts every row in students.
+func storedStudents(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM students").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStreamRollsBackOnBadLine(t *testing.T) {
+	newTestDB(t)
+	defer func(n int) { bulkChunkSize = n }(bulkChunkSize)
+	bulkChunkSize = 2
+
+	// The first two lines are written before line 5 is read.
+	rec := stream(t, `{"name":"Ann","age":20,"gpa":3.0}
+{"name":"Bob","age":20,"gpa":3.0}
+
+{"name":"Cy","age":20,"gpa":3.0}
+{"name":"","age":20,"gpa":3.0}
+`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Line int `json:"line"`
+	}
+	decodeBody(t, rec, &resp)
+	if resp.Line != 5 {
+		t.Errorf("got line %d, want 5", resp.Line)
+	}
+	if n := storedStudents(t); n != 0 {
+		t.Errorf("%d students stored, want none", n)
+	}
+}
+
+func TestStreamRejectsDuplicateAcrossChunks(t *testing.T) {
+	newTestDB(t)
+	defer func(n int) { bulkChunkSize = n }(bulkChunkSize)
+	bulkChunkSize = 2
+
+	rec := stream(t, `{"name":"Ann","age":20,"gpa":3.0,"email":"ann@example.com"}
+{"name":"Bob","age":20,"gpa":3.0}
+{"name":"Cy","age":20,"gpa":3.0,"email":"ANN@example.com"}
+`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	if n := storedStudents(t); n != 0 {
+		t.Errorf("%d students stored, want none", n)
+	}
+}
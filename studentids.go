@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// New student IDs come from the students_id_seq sequence. Unlike a
+// MAX(id)+1 lookup it can't hand the same ID to two writers, whether they're
+// concurrent requests or another process sharing the file. Values are never
+// given back, so a rolled-back insert (or a bulk dry run) leaves a gap.
+//
+// The sequence isn't the column's DEFAULT: DuckDB won't replace a sequence a
+// table depends on, and syncStudentIDSeq needs to.
+
+// queryExecer is the QueryRow and Exec halves of *sql.DB and *sql.Tx.
+type queryExecer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// nextStudentID takes the next ID from students_id_seq.
+func nextStudentID(q queryExecer) (int64, error) {
+	var id int64
+	err := q.QueryRow("SELECT nextval('students_id_seq')").Scan(&id)
+	return id, err
+}
+
+// syncStudentIDSeq makes sure students_id_seq exists and will hand out IDs
+// above every existing student, recreating it when it's behind. initDB calls
+// it at startup (which creates the sequence for databases from before it),
+// and writes that choose their own IDs, like an upsert or a backup restore,
+// call it before committing. On a tx the change commits or rolls back with
+// the rest of the write.
+func syncStudentIDSeq(q queryExecer) error {
+	var maxID int64
+	if err := q.QueryRow("SELECT COALESCE(MAX(id), 0) FROM students").Scan(&maxID); err != nil {
+		return err
+	}
+	var start int64
+	var last sql.NullInt64
+	err := q.QueryRow("SELECT start_value, last_value FROM duckdb_sequences() WHERE sequence_name = 'students_id_seq'").Scan(&start, &last)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case last.Valid && last.Int64 >= maxID, !last.Valid && start > maxID:
+		return nil
+	}
+	_, err = q.Exec(fmt.Sprintf("CREATE OR REPLACE SEQUENCE students_id_seq START %d", maxID+1))
+	return err
+}
+
+// resetStudentIDSeq starts students_id_seq again at 1, for an emptied table.
+func resetStudentIDSeq(q execer) error {
+	_, err := q.Exec("CREATE OR REPLACE SEQUENCE students_id_seq START 1")
+	return err
+}